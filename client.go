@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// client sends requests to the editor. None of its methods may be called on the
+// goroutine that reads the connection, the response could never be delivered otherwise.
+type client struct {
+	caller *rpc.Caller
+}
+
+// configuration asks the editor for the settings of the given sections, one result per item.
+func (c *client) configuration(ctx context.Context, items []lsp.ConfigurationItem, result any) error {
+	return c.caller.Call(ctx, "workspace/configuration", lsp.ConfigurationParams{Items: items}, result)
+}
+
+func (c *client) registerCapability(ctx context.Context, registrations ...lsp.Registration) error {
+	return c.caller.Call(ctx, "client/registerCapability", lsp.RegistrationParams{Registrations: registrations}, nil)
+}
+
+// showMessageRequest returns the action the user picked, nil if the message was dismissed.
+func (c *client) showMessageRequest(ctx context.Context, params lsp.ShowMessageRequestParams) (*lsp.MessageActionItem, error) {
+	var picked *lsp.MessageActionItem
+	if err := c.caller.Call(ctx, "window/showMessageRequest", params, &picked); err != nil {
+		return nil, err
+	}
+	return picked, nil
+}
+
+func (c *client) applyEdit(ctx context.Context, params lsp.ApplyWorkspaceEditParams) (lsp.ApplyWorkspaceEditResult, error) {
+	var result lsp.ApplyWorkspaceEditResult
+	err := c.caller.Call(ctx, "workspace/applyEdit", params, &result)
+	return result, err
+}
+
+func (c *client) createWorkDoneProgress(ctx context.Context, token lsp.ProgressToken) error {
+	return c.caller.Call(ctx, "window/workDoneProgress/create", lsp.WorkDoneProgressCreateParams{Token: token}, nil)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"sclls/internal"
+	"sclls/lsp"
+)
+
+// diagnosticsScheduler debounces the publishing of diagnostics per document.
+// Every change restarts the delay of its document and cancels a run that is still computing,
+// so only the latest content is published once the client stops typing.
+type diagnosticsScheduler struct {
+	s     *session
+	delay time.Duration
+
+	mu   sync.Mutex
+	runs map[string]*diagnosticsRun
+	wg   sync.WaitGroup
+	// After close nothing gets scheduled anymore
+	closed bool
+}
+
+type diagnosticsRun struct {
+	timer  *time.Timer
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDiagnosticsScheduler(s *session, delay time.Duration) *diagnosticsScheduler {
+	return &diagnosticsScheduler{s: s, delay: delay, runs: make(map[string]*diagnosticsRun)}
+}
+
+// schedule publishes the diagnostics of uri after the delay, unless it is scheduled again before.
+func (d *diagnosticsScheduler) schedule(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.stopLocked(uri)
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &diagnosticsRun{ctx: ctx, cancel: cancel}
+	d.wg.Add(1)
+	run.timer = time.AfterFunc(d.delay, func() {
+		defer d.wg.Done()
+		d.run(uri, run)
+	})
+	d.runs[uri] = run
+}
+
+// cancel drops a pending or running publish of uri, e.g. because the document was closed.
+func (d *diagnosticsScheduler) cancel(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopLocked(uri)
+}
+
+func (d *diagnosticsScheduler) stopLocked(uri string) {
+	run, ok := d.runs[uri]
+	if !ok {
+		return
+	}
+	run.cancel()
+	if run.timer.Stop() {
+		// Never started, so it will not mark itself as done
+		d.wg.Done()
+	}
+	delete(d.runs, uri)
+}
+
+func (d *diagnosticsScheduler) run(uri string, run *diagnosticsRun) {
+	diagnostics, version, err := d.s.state.Diagnostics(run.ctx, uri)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, internal.ErrDocumentChanged) {
+			d.s.logger.Debug("Not publishing diagnostics", "uri", uri, "err", err)
+		}
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.runs[uri] != run {
+		// Something newer is scheduled already
+		return
+	}
+	delete(d.runs, uri)
+	publishDiagnostics(d.s, uri, version, diagnostics)
+}
+
+// flush starts all pending runs right away and waits until they are done.
+func (d *diagnosticsScheduler) flush() {
+	d.mu.Lock()
+	for uri, run := range d.runs {
+		if run.timer.Stop() {
+			go func() {
+				defer d.wg.Done()
+				d.run(uri, run)
+			}()
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// close cancels everything that is still pending.
+func (d *diagnosticsScheduler) close() {
+	d.mu.Lock()
+	d.closed = true
+	for uri := range d.runs {
+		d.stopLocked(uri)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// publishDiagnostics sends diagnostics that were computed for version of the document, nil if it is unknown.
+func publishDiagnostics(s *session, uri string, version *int, diagnostics []lsp.Diagnostic) {
+	params := lsp.PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: diagnostics,
+	}
+	writeResponse(s.writer, lsp.PublishDiagnosticsNotificiation{
+		Notification: lsp.Notification{
+			RPC:    "2.0",
+			Method: "textDocument/publishDiagnostics",
+		},
+		Params: params,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// dispatcher hands incoming messages to handleMessage.
+// Notifications and lifecycle requests are handled in order on the reading goroutine,
+// all other requests run in their own goroutine and can be cancelled via $/cancelRequest.
+// Responses to our own requests are passed on to the client.
+type dispatcher struct {
+	*session
+
+	mu       sync.Mutex
+	inFlight map[lsp.ID]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newDispatcher(routes *registry, logger *slog.Logger, writer *rpc.Writer, state *internal.State) *dispatcher {
+	s := &session{
+		logger: logger,
+		writer: writer,
+		state:  state,
+		client: &client{caller: rpc.NewCaller(writer)},
+		routes: routes,
+	}
+	s.diagnostics = newDiagnosticsScheduler(s, state.DiagnosticsDelay)
+	s.reloader = newNeedsReloader(s)
+	s.stopNeedsUpdates = state.Needs.OnUpdate(s.needsChanged)
+	return &dispatcher{
+		session:  s,
+		inFlight: make(map[lsp.ID]context.CancelFunc),
+	}
+}
+
+func (d *dispatcher) dispatch(method string, contents []byte) {
+	baseMsg, err := rpc.DecodeBaseMessage(contents)
+	if err == nil && baseMsg.IsResponse() {
+		if !d.client.caller.Deliver(contents) {
+			d.logger.Warn("Dropping response, no request is waiting for it", "id", string(baseMsg.ID))
+		}
+		return
+	}
+	if method == "$/cancelRequest" {
+		d.cancel(contents)
+		return
+	}
+	if err != nil || baseMsg.IsNotification() {
+		handleMessage(context.Background(), d.session, method, contents)
+		return
+	}
+	if d.runsInOrder(method) {
+		// Requests read before shutdown still have to be answered, and shutdown frees the documents and needs
+		// they work on. None of them waits for the client, that would need this goroutine to deliver the response.
+		d.wait()
+		handleMessage(context.Background(), d.session, method, contents)
+		return
+	}
+	id := requestID(baseMsg)
+	if id == nil {
+		handleMessage(context.Background(), d.session, method, contents)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	if _, ok := d.inFlight[*id]; ok {
+		d.mu.Unlock()
+		cancel()
+		// $/cancelRequest could not tell them apart anymore
+		writeResponse(d.writer, lsp.NewErrorResponse(id, lsp.NewResponseError(lsp.InvalidRequest, "request %s is still running, ids must be unique", id.String())))
+		return
+	}
+	d.inFlight[*id] = cancel
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.inFlight, *id)
+			d.mu.Unlock()
+			cancel()
+		}()
+		handleMessage(ctx, d.session, method, contents)
+	}()
+}
+
+// cancel stops the request named in a $/cancelRequest notification, if it is still running.
+func (d *dispatcher) cancel(contents []byte) {
+	var notification lsp.CancelRequestNotification
+	if err := json.Unmarshal(contents, &notification); err != nil {
+		d.logger.Warn("could not parse cancel request", "err", err)
+		return
+	}
+	d.mu.Lock()
+	cancel, ok := d.inFlight[notification.Params.ID]
+	d.mu.Unlock()
+	if !ok {
+		// Already answered, nothing to do
+		return
+	}
+	d.logger.Debug("Cancelling request", "id", notification.Params.ID.String())
+	cancel()
+}
+
+// wait blocks until all running requests have been answered.
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// close stops the session. Requests we sent to the client will never be answered now.
+func (d *dispatcher) close() {
+	d.stopNeedsUpdates()
+	d.stopPolling()
+	d.client.caller.Close()
+	d.wait()
+	d.reloader.close()
+	d.diagnostics.close()
+}
+
+// runsInOrder reports whether a request may not overtake other messages, e.g. because it changes the lifecycle.
+func (d *dispatcher) runsInOrder(method string) bool {
+	rt, ok := d.routes.lookup(method)
+	return ok && rt.inOrder
+}
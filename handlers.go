@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+
+	"sclls/internal"
+	"sclls/lsp"
+)
+
+// newRegistry registers all methods the server understands.
+// Methods in disabled are neither advertised nor handled.
+func newRegistry(disabled []string) *registry {
+	r := newRegistryWithMiddleware(disabled, recoverPanics, logTiming, traceMessages, checkLifecycle, gateCapabilities, checkContentModified)
+
+	// Lifecycle, these change the status of the session and therefore run in order
+	onRequest(r, "initialize", nil, handleInitialize).inOrder = true
+	onNotification(r, "initialized", nil, handleInitialized)
+	onRequest(r, "shutdown", nil, handleShutdown).inOrder = true
+	onNotification(r, "exit", nil, handleExit)
+	onNotification(r, "$/setTrace", nil, handleSetTrace)
+
+	// Registered on initialized, not advertised
+	onNotification(r, "workspace/didChangeWatchedFiles", nil, handleDidChangeWatchedFiles)
+
+	// Document synchronization
+	onNotification(r, "textDocument/didOpen", func(c *lsp.ServerCapabilities) { syncOptions(c).OpenClose = true }, handleDidOpen)
+	onNotification(r, "textDocument/didChange", func(c *lsp.ServerCapabilities) { syncOptions(c).Change = lsp.TextDocumentSyncIncremental }, handleDidChange)
+	onNotification(r, "textDocument/didClose", func(c *lsp.ServerCapabilities) { syncOptions(c).OpenClose = true }, handleDidClose)
+	// The saved text is checked again, it is what the synced buffer has as well
+	onNotification(r, "textDocument/didSave", func(c *lsp.ServerCapabilities) { syncOptions(c).Save = &lsp.SaveOptions{IncludeText: true} }, handleDidSave)
+
+	// Language features
+	onRequest(r, "textDocument/hover", func(c *lsp.ServerCapabilities) { c.HoverProvider = true }, handleHover)
+	onRequest(r, "textDocument/definition", func(c *lsp.ServerCapabilities) { c.DefinitionProvider = true }, handleDefinition)
+	onRequest(r, "textDocument/references", func(c *lsp.ServerCapabilities) { c.ReferencesProvider = true }, handleReferences)
+	onRequest(r, "textDocument/completion", func(c *lsp.ServerCapabilities) { c.CompletionProvider = map[string]any{} }, handleCompletion)
+	return r
+}
+
+// syncOptions lets each document synchronization route fill in its part of the capability.
+func syncOptions(c *lsp.ServerCapabilities) *lsp.TextDocumentSyncOptions {
+	if c.TextDocumentSync == nil {
+		c.TextDocumentSync = &lsp.TextDocumentSyncOptions{}
+	}
+	return c.TextDocumentSync
+}
+
+func handleInitialize(_ context.Context, s *session, request lsp.InitializeRequest) (any, error) {
+	if request.Params.ClientInfo != nil {
+		s.logger.Info("Connected", "client", request.Params.ClientInfo.Name, "version", request.Params.ClientInfo.Version)
+	}
+	if err := s.state.Initialize(); err != nil {
+		return nil, lsp.NewResponseError(lsp.InvalidRequest, "%s", err.Error())
+	}
+	s.setTrace(request.Params.Trace)
+	var offered []lsp.PositionEncodingKind
+	if request.Params.Capabilities.General != nil {
+		offered = request.Params.Capabilities.General.PositionEncodings
+	}
+	if workspace := request.Params.Capabilities.Workspace; workspace != nil && workspace.DidChangeWatchedFiles != nil {
+		s.clientWatchesFiles = workspace.DidChangeWatchedFiles.DynamicRegistration
+	}
+	encoding := internal.NegotiatePositionEncoding(offered)
+	s.state.SetPositionEncoding(encoding)
+	capabilities := s.routes.capabilities()
+	capabilities.PositionEncoding = encoding
+	return lsp.NewInitializeReponse(request.ID, capabilities), nil
+}
+
+func handleInitialized(_ context.Context, s *session, _ lsp.Notification) error {
+	s.state.Initialized()
+	s.logger.Info("Client finished initialization")
+	s.watchNeedsJson()
+	return nil
+}
+
+func handleShutdown(_ context.Context, s *session, request lsp.Request) (any, error) {
+	// Publish what is still pending, the documents are gone afterwards
+	s.diagnostics.flush()
+	s.state.Shutdown()
+	// Nothing is reloaded for a session that is going away
+	s.reloader.close()
+	return lsp.NewShutdownResponse(request.ID), nil
+}
+
+func handleExit(_ context.Context, s *session, _ lsp.Notification) error {
+	s.state.Exit()
+	return nil
+}
+
+func handleSetTrace(_ context.Context, s *session, notification lsp.SetTraceNotification) error {
+	s.setTrace(notification.Params.Value)
+	return nil
+}
+
+func handleDidOpen(_ context.Context, s *session, request lsp.DidOpenTextDocumentNotification) error {
+	s.logger.Debug("Opened document", "uri", request.Params.TextDocument.URI)
+	s.state.OpenDocumentVersion(request.Params.TextDocument.URI, request.Params.TextDocument.Version, request.Params.TextDocument.Text)
+	s.diagnostics.schedule(request.Params.TextDocument.URI)
+	return nil
+}
+
+func handleDidChange(_ context.Context, s *session, request lsp.TextDocumentDidChangeNotification) error {
+	s.logger.Debug("Changed document", "uri", request.Params.TextDocument.URI)
+	if err := s.state.ChangeDocument(request.Params.TextDocument.URI, request.Params.TextDocument.Version, request.Params.ContentChanges); err != nil {
+		return err
+	}
+	s.diagnostics.schedule(request.Params.TextDocument.URI)
+	return nil
+}
+
+func handleDidClose(_ context.Context, s *session, request lsp.DidCloseTextDocumentNotification) error {
+	s.logger.Debug("Closed document", "uri", request.Params.TextDocument.URI)
+	s.diagnostics.cancel(request.Params.TextDocument.URI)
+	if !s.state.CloseDocument(request.Params.TextDocument.URI) {
+		s.logger.Debug("Closed document was not open", "uri", request.Params.TextDocument.URI)
+	}
+	// Otherwise the client keeps showing the diagnostics of a file nobody looks at
+	publishDiagnostics(s, request.Params.TextDocument.URI, nil, []lsp.Diagnostic{})
+	return nil
+}
+
+func handleDidSave(_ context.Context, s *session, request lsp.DidSaveTextDocumentNotification) error {
+	s.logger.Debug("Saved document", "uri", request.Params.TextDocument.URI)
+	// The file on disk changed, whether we know the document or not
+	if s.isRstSource(request.Params.TextDocument.URI) {
+		s.reloader.schedule(false)
+	}
+	if err := s.state.SaveDocument(request.Params.TextDocument.URI, request.Params.Text); err != nil {
+		return err
+	}
+	s.diagnostics.schedule(request.Params.TextDocument.URI)
+	return nil
+}
+
+func handleDidChangeWatchedFiles(_ context.Context, s *session, notification lsp.DidChangeWatchedFilesNotification) error {
+	for _, change := range notification.Params.Changes {
+		switch {
+		case change.Type != lsp.FileDeleted && s.isNeedsJson(change.URI):
+			s.reloader.schedule(true)
+		case s.isRstSource(change.URI):
+			// A deleted rst file takes its needs with it
+			s.reloader.schedule(false)
+		}
+	}
+	return nil
+}
+
+// Hover msg ('K')
+func handleHover(_ context.Context, s *session, request lsp.HoverRequest) (any, error) {
+	var responseStr string
+	foundNeed, err := s.state.FindNeedsInRequestedPosition(request.Params.TextDocument.URI, request.Params.Position)
+	if err != nil {
+		responseStr = err.Error()
+	} else {
+		responseStr = foundNeed.GenerateHoverInfo(s.state.HoverFields)
+	}
+	return lsp.HoverResponse{
+		Response: lsp.Response{
+			RPC: "2.0",
+			ID:  &request.ID,
+		},
+		Result: lsp.HoverResult{
+			Contents: responseStr,
+		},
+	}, nil
+}
+
+// Def request ('gd')
+func handleDefinition(_ context.Context, s *session, request lsp.DefinitionRequest) (any, error) {
+	s.logger.Debug("Definition requested", "id", request.ID.String(), "uri", request.Params.TextDocument.URI, "line", request.Params.Position.Line, "character", request.Params.Position.Character)
+	return s.state.GoToDefinition(request.ID, request.Params.TextDocument.URI, request.Params.Position), nil
+}
+
+func handleReferences(ctx context.Context, s *session, request lsp.ReferencesRequest) (any, error) {
+	return s.state.References(ctx, request.ID, request.Params.TextDocument.URI, request.Params.Position, request.Params.Context.IncludeDeclaration)
+}
+
+func handleCompletion(ctx context.Context, s *session, request lsp.CompletionRequest) (any, error) {
+	return s.state.TextDocumentCompletion(ctx, request.ID, request.Params.TextDocument.URI, request.Params.Position)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sclls/lsp"
+)
+
+// DefaultIDCharacters is the class of characters need IDs are made of, unless configured otherwise.
+const DefaultIDCharacters = `[A-Za-z0-9_]`
+
+var defaultIDCharacter = regexp.MustCompile(`^(?:` + DefaultIDCharacters + `)$`)
+
+// ReferenceKind tells how a need is referenced at a position.
+type ReferenceKind string
+
+const (
+	// A need listed after a template string, e.g. "# req-Id: REQ_001"
+	ReferenceTraceLink ReferenceKind = "traceLink"
+	// A need that is only mentioned somewhere else, e.g. in a comment
+	ReferenceMention ReferenceKind = "mention"
+)
+
+// NeedDetector decides which occurrences of need IDs are references to a need.
+// An ID only counts if it is not part of a longer identifier, i.e. the characters
+// around it are no ID characters, and of overlapping IDs only the longest one counts.
+type NeedDetector struct {
+	// Matches exactly one ID character, nil for DefaultIDCharacters
+	idCharacter *regexp.Regexp
+	templates   []string
+}
+
+// NewNeedDetector creates a detector for IDs made of idCharacters, a regular expression matching
+// a single character such as "[A-Za-z0-9_-]". Empty means DefaultIDCharacters.
+// Needs after one of the templates at the start of a line are trace links.
+func NewNeedDetector(idCharacters string, templates []string) (*NeedDetector, error) {
+	nd := &NeedDetector{templates: templates}
+	if idCharacters == "" {
+		return nd, nil
+	}
+	re, err := regexp.Compile(`^(?:` + idCharacters + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID characters %q: %w", idCharacters, err)
+	}
+	nd.idCharacter = re
+	return nd, nil
+}
+
+func (nd *NeedDetector) isIDCharacter(r rune) bool {
+	re := nd.idCharacter
+	if re == nil {
+		re = defaultIDCharacter
+	}
+	return re.MatchString(string(r))
+}
+
+// atBoundary reports if the match is not surrounded by ID characters.
+func (nd *NeedDetector) atBoundary(content string, m Match) bool {
+	if m.Start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(content[:m.Start]); nd.isIDCharacter(r) {
+			return false
+		}
+	}
+	if m.End < len(content) {
+		if r, _ := utf8.DecodeRuneInString(content[m.End:]); nd.isIDCharacter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// kind checks if the need on line at the byte offset column comes after a template string.
+func (nd *NeedDetector) kind(line string, column int) ReferenceKind {
+	for _, tmpl := range nd.templates {
+		if tmpl != "" && strings.HasPrefix(line, tmpl) && column >= len(tmpl) {
+			return ReferenceTraceLink
+		}
+	}
+	return ReferenceMention
+}
+
+// Find returns the references to the needs of matcher in doc, with the columns counted in enc.
+// The needs are in the order they first appear in.
+func (nd *NeedDetector) Find(doc *Document, matcher *NeedMatcher, enc lsp.PositionEncodingKind) []NeedDocInfo {
+	content := doc.Content()
+	matches := matcher.Matches(content)
+	// Leftmost first, of those starting at the same place the longest
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].End > matches[j].End
+	})
+
+	var result []NeedDocInfo
+	byID := make(map[string]int)
+	end := 0
+	for _, m := range matches {
+		if m.Start < end || !nd.atBoundary(content, m) {
+			continue
+		}
+		end = m.End
+		pos := doc.Position(m.Start, enc)
+		position := NeedPositionInfo{
+			Line:     pos.Line,
+			StartCol: pos.Character,
+			EndCol:   pos.Character + CharacterCount(m.ID, enc),
+			Kind:     nd.kind(doc.Line(pos.Line), m.Start-doc.lineStarts[pos.Line]),
+		}
+		n, ok := byID[m.ID]
+		if !ok {
+			n = len(result)
+			byID[m.ID] = n
+			result = append(result, NeedDocInfo{Need: matcher.Need(m.ID)})
+		}
+		result[n].Positions = append(result[n].Positions, position)
+	}
+	return result
+}
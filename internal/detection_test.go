@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"sclls/lsp"
+)
+
+func TestNeedDetector(t *testing.T) {
+	needs := NeedsInfo{
+		"tool_req__x":          {ID: "tool_req__x"},
+		"tool_req__x_extended": {ID: "tool_req__x_extended"},
+		"REQ-1":                {ID: "REQ-1"},
+		"REQ-1-2":              {ID: "REQ-1-2"},
+		"REQ":                  {ID: "REQ"},
+	}
+	templates := []string{"# req-Id: ", "# req-traceability: "}
+	tests := []struct {
+		name         string
+		idCharacters string
+		content      string
+		want         []NeedDocInfo
+	}{
+		{
+			name:    "not inside longer identifiers",
+			content: "my_tool_req__x = 1\ntool_req__x_extended\ntool_req__xy",
+			want: []NeedDocInfo{
+				{Need: needs["tool_req__x_extended"], Positions: []NeedPositionInfo{{Line: 1, StartCol: 0, EndCol: 20, Kind: ReferenceMention}}},
+			},
+		},
+		{
+			name:    "separated by punctuation",
+			content: "(tool_req__x), tool_req__x.",
+			want: []NeedDocInfo{
+				{Need: needs["tool_req__x"], Positions: []NeedPositionInfo{
+					{Line: 0, StartCol: 1, EndCol: 12, Kind: ReferenceMention},
+					{Line: 0, StartCol: 15, EndCol: 26, Kind: ReferenceMention},
+				}},
+			},
+		},
+		{
+			name:    "longest match wins",
+			content: "REQ-1-2 REQ-1 REQ",
+			want: []NeedDocInfo{
+				{Need: needs["REQ-1-2"], Positions: []NeedPositionInfo{{Line: 0, StartCol: 0, EndCol: 7, Kind: ReferenceMention}}},
+				{Need: needs["REQ-1"], Positions: []NeedPositionInfo{{Line: 0, StartCol: 8, EndCol: 13, Kind: ReferenceMention}}},
+				{Need: needs["REQ"], Positions: []NeedPositionInfo{{Line: 0, StartCol: 14, EndCol: 17, Kind: ReferenceMention}}},
+			},
+		},
+		{
+			name:         "dash is an ID character",
+			idCharacters: `[A-Za-z0-9_-]`,
+			content:      "REQ-1-2 REQ-1-3",
+			want: []NeedDocInfo{
+				{Need: needs["REQ-1-2"], Positions: []NeedPositionInfo{{Line: 0, StartCol: 0, EndCol: 7, Kind: ReferenceMention}}},
+			},
+		},
+		{
+			name:    "trace links and mentions",
+			content: "# req-Id: tool_req__x\n# see tool_req__x\n  # req-traceability: REQ, REQ-1",
+			want: []NeedDocInfo{
+				{Need: needs["tool_req__x"], Positions: []NeedPositionInfo{
+					{Line: 0, StartCol: 10, EndCol: 21, Kind: ReferenceTraceLink},
+					{Line: 1, StartCol: 6, EndCol: 17, Kind: ReferenceMention},
+				}},
+				{Need: needs["REQ"], Positions: []NeedPositionInfo{{Line: 2, StartCol: 22, EndCol: 25, Kind: ReferenceMention}}},
+				{Need: needs["REQ-1"], Positions: []NeedPositionInfo{{Line: 2, StartCol: 27, EndCol: 32, Kind: ReferenceMention}}},
+			},
+		},
+		{
+			name:    "template has to match completely",
+			content: "# req-traceability:REQ",
+			want: []NeedDocInfo{
+				{Need: needs["REQ"], Positions: []NeedPositionInfo{{Line: 0, StartCol: 19, EndCol: 22, Kind: ReferenceMention}}},
+			},
+		},
+	}
+
+	matcher := NewNeedMatcher(needs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewNeedDetector(tt.idCharacters, templates)
+			if err != nil {
+				t.Fatalf("NewNeedDetector() error = %v", err)
+			}
+			got := detector.Find(NewDocument(tt.content), matcher, lsp.PositionEncodingUTF16)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewNeedDetector("[a-z", nil); err == nil {
+		t.Errorf("NewNeedDetector() accepted an invalid regular expression")
+	}
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sclls/lsp"
+)
+
+var ErrDocumentChanged = errors.New("document changed while its diagnostics were computed")
+
+// Diagnostics returns the diagnostics of the current content of the document and the version they belong to,
+// nil if the client did not give it one.
+// Stale diagnostics are computed again without holding the lock, so changes are not blocked meanwhile.
+// If the document changes in the meantime the result is thrown away and ErrDocumentChanged is returned.
+func (s *State) Diagnostics(ctx context.Context, uri string) ([]lsp.Diagnostic, *int, error) {
+	s.mu.RLock()
+	di, ok := s.Documents[uri]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, nil, fmt.Errorf("document %s is not open", uri)
+	}
+	if !di.diagnosticsStale {
+		diagnostics, version := di.Diagnostics, di.Version
+		s.mu.RUnlock()
+		if diagnostics == nil {
+			diagnostics = []lsp.Diagnostic{}
+		}
+		return diagnostics, version, nil
+	}
+	// The document is never changed, only replaced, so it can be used after unlocking
+	doc, revision, needs, enc := di.document(), di.revision, s.needsList(), s.positionEncoding()
+	s.mu.RUnlock()
+
+	diagnostics, err := s.findDiagnostics(ctx, doc, needs, enc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	di, ok = s.Documents[uri]
+	if !ok || di.revision != revision {
+		return nil, nil, ErrDocumentChanged
+	}
+	di.Diagnostics = diagnostics
+	di.diagnosticsStale = false
+	return diagnostics, di.Version, nil
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	state := createTestState()
+	uri := "file:///test.py"
+	if _, _, err := state.Diagnostics(context.Background(), uri); err == nil {
+		t.Error("Expected an error for a document that is not open")
+	}
+
+	state.OpenDocumentVersion(uri, 7, "# req-Id: MISSING\n# req-Id: REQ_001")
+	if state.Documents[uri].Diagnostics != nil {
+		t.Error("Expected diagnostics not to be computed when opening")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := state.Diagnostics(ctx, uri); !errors.Is(err, context.Canceled) {
+		t.Errorf("Diagnostics() error = %v, want %v", err, context.Canceled)
+	}
+
+	diagnostics, version, err := state.Diagnostics(context.Background(), uri)
+	if err != nil || version == nil || *version != 7 || len(diagnostics) != 1 {
+		t.Fatalf("Diagnostics() = %v, version %v, error %v, want 1 diagnostic for version 7", diagnostics, version, err)
+	}
+	// Computed once, now they are reused even with a cancelled context
+	if again, _, err := state.Diagnostics(ctx, uri); err != nil || len(again) != 1 {
+		t.Errorf("Expected the computed diagnostics to be reused, got %v (err %v)", again, err)
+	}
+}
+
+func TestDiagnosticsDuringShutdown(t *testing.T) {
+	state := createTestState()
+	uri := "file:///test.py"
+	// Big enough to still be checked when shutdown comes
+	state.OpenDocumentVersion(uri, 1, strings.Repeat("# req-Id: MISSING\n# req-Id: REQ_001\n", 5000))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		state.Shutdown()
+	}()
+	// Either computed with the needs from before shutdown or not at all, never with a nil index
+	_, _, _ = state.Diagnostics(context.Background(), uri)
+	<-done
+}
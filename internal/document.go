@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+
+	"sclls/lsp"
+)
+
+// Document is the content of a file together with the offsets its lines start at.
+// It is never changed after creation, so it can be read without holding any lock.
+type Document struct {
+	content    string
+	lineStarts []int
+}
+
+func NewDocument(content string) *Document {
+	lineStarts := make([]int, 1, strings.Count(content, "\n")+1)
+	for offset := 0; ; {
+		idx := strings.IndexByte(content[offset:], '\n')
+		if idx == -1 {
+			break
+		}
+		offset += idx + 1
+		lineStarts = append(lineStarts, offset)
+	}
+	return &Document{content: content, lineStarts: lineStarts}
+}
+
+func (d *Document) Content() string {
+	return d.content
+}
+
+// LineCount is the number of lines, content ending with a newline has an empty last line.
+func (d *Document) LineCount() int {
+	return len(d.lineStarts)
+}
+
+// Line returns line n without its line ending, "" if there is no such line.
+func (d *Document) Line(n int) string {
+	if n < 0 || n >= len(d.lineStarts) {
+		return ""
+	}
+	line := d.content[d.lineStarts[n]:d.lineEnd(n)]
+	return strings.TrimSuffix(line, "\r")
+}
+
+// Lines returns the lines first to last (both included) with their line endings.
+func (d *Document) Lines(first, last int) string {
+	first = max(first, 0)
+	if first >= len(d.lineStarts) || last < first {
+		return ""
+	}
+	end := len(d.content)
+	if last+1 < len(d.lineStarts) {
+		end = d.lineStarts[last+1]
+	}
+	return d.content[d.lineStarts[first]:end]
+}
+
+// lineEnd is the offset of the newline that ends line n, or the end of the content.
+func (d *Document) lineEnd(n int) int {
+	if n+1 < len(d.lineStarts) {
+		return d.lineStarts[n+1] - 1
+	}
+	return len(d.content)
+}
+
+// Position converts a byte offset into a position with the character counted in enc.
+func (d *Document) Position(offset int, enc lsp.PositionEncodingKind) lsp.Position {
+	offset = min(max(offset, 0), len(d.content))
+	// The last line starting at or before offset
+	line := sort.Search(len(d.lineStarts), func(i int) bool { return d.lineStarts[i] > offset }) - 1
+	return lsp.Position{
+		Line:      line,
+		Character: CharacterCount(d.content[d.lineStarts[line]:offset], enc),
+	}
+}
+
+// Offset converts a position (counted in enc) into a byte offset.
+// Positions past the end of a line or the document are clamped, as the spec asks.
+func (d *Document) Offset(pos lsp.Position, enc lsp.PositionEncodingKind) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.content)
+	}
+	start := d.lineStarts[pos.Line]
+	return start + ByteOffset(d.content[start:d.lineEnd(pos.Line)], pos.Character, enc)
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"testing"
+
+	"sclls/lsp"
+)
+
+func TestDocumentLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   []string
+	}{
+		{"empty", "", []string{""}},
+		{"no trailing newline", "ab\ncd", []string{"ab", "cd"}},
+		{"trailing newline", "ab\n", []string{"ab", ""}},
+		{"windows line endings", "ab\r\n\r\ncd", []string{"ab", "", "cd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewDocument(tt.content)
+			if doc.LineCount() != len(tt.lines) {
+				t.Fatalf("LineCount() = %d, want %d", doc.LineCount(), len(tt.lines))
+			}
+			for i, line := range tt.lines {
+				if got := doc.Line(i); got != line {
+					t.Errorf("Line(%d) = %q, want %q", i, got, line)
+				}
+			}
+			if got := doc.Line(len(tt.lines)); got != "" {
+				t.Errorf("Line() past the end = %q, want empty", got)
+			}
+			if got := doc.Lines(0, len(tt.lines)); got != tt.content {
+				t.Errorf("Lines() of everything = %q, want %q", got, tt.content)
+			}
+		})
+	}
+
+	doc := NewDocument("a\nb\nc")
+	if got := doc.Lines(1, 1); got != "b\n" {
+		t.Errorf("Lines(1, 1) = %q, want %q", got, "b\n")
+	}
+	if got := doc.Lines(2, 1); got != "" {
+		t.Errorf("Lines(2, 1) = %q, want empty", got)
+	}
+}
+
+func TestDocumentOffsetAndPosition(t *testing.T) {
+	doc := NewDocument("ab\n\nc😀d")
+	tests := []struct {
+		pos    lsp.Position
+		offset int
+	}{
+		{lsp.Position{Line: 0, Character: 0}, 0},
+		{lsp.Position{Line: 0, Character: 2}, 2},
+		{lsp.Position{Line: 1, Character: 0}, 3},
+		{lsp.Position{Line: 2, Character: 1}, 5},
+		{lsp.Position{Line: 2, Character: 3}, 9},
+		{lsp.Position{Line: 2, Character: 4}, 10},
+	}
+	for _, tt := range tests {
+		if got := doc.Offset(tt.pos, lsp.PositionEncodingUTF16); got != tt.offset {
+			t.Errorf("Offset(%d:%d) = %d, want %d", tt.pos.Line, tt.pos.Character, got, tt.offset)
+		}
+		if got := doc.Position(tt.offset, lsp.PositionEncodingUTF16); got != tt.pos {
+			t.Errorf("Position(%d) = %d:%d, want %d:%d", tt.offset, got.Line, got.Character, tt.pos.Line, tt.pos.Character)
+		}
+	}
+
+	clamped := []struct {
+		pos    lsp.Position
+		offset int
+	}{
+		{lsp.Position{Line: 0, Character: 9}, 2},
+		{lsp.Position{Line: 1, Character: 3}, 3},
+		{lsp.Position{Line: 7, Character: 0}, 10},
+		{lsp.Position{Line: -1, Character: 0}, 0},
+	}
+	for _, tt := range clamped {
+		if got := doc.Offset(tt.pos, lsp.PositionEncodingUTF16); got != tt.offset {
+			t.Errorf("Offset(%d:%d) = %d, want %d", tt.pos.Line, tt.pos.Character, got, tt.offset)
+		}
+	}
+	if got := doc.Position(100, lsp.PositionEncodingUTF16); got != (lsp.Position{Line: 2, Character: 4}) {
+		t.Errorf("Position() past the end = %d:%d, want 2:4", got.Line, got.Character)
+	}
+}
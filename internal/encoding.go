@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"slices"
+	"unicode/utf8"
+
+	"sclls/lsp"
+)
+
+// supportedEncodings are all encodings we can count characters in.
+var supportedEncodings = []lsp.PositionEncodingKind{lsp.PositionEncodingUTF8, lsp.PositionEncodingUTF32, lsp.PositionEncodingUTF16}
+
+// NegotiatePositionEncoding picks the first encoding the client offers that we support.
+// Clients that offer nothing (or nothing we know) get UTF-16, as the spec demands.
+func NegotiatePositionEncoding(offered []lsp.PositionEncodingKind) lsp.PositionEncodingKind {
+	for _, enc := range offered {
+		if slices.Contains(supportedEncodings, enc) {
+			return enc
+		}
+	}
+	return lsp.PositionEncodingUTF16
+}
+
+// runeLength is how many characters r counts as in enc.
+func runeLength(r rune, size int, enc lsp.PositionEncodingKind) int {
+	switch enc {
+	case lsp.PositionEncodingUTF8:
+		return size
+	case lsp.PositionEncodingUTF32:
+		return 1
+	}
+	// UTF-16, also if nothing was negotiated
+	if r >= 0x10000 {
+		// Surrogate pair
+		return 2
+	}
+	return 1
+}
+
+// CharacterCount returns how many characters text is long in enc.
+// Use it on the part of a line in front of a byte offset to get the character of a Position.
+func CharacterCount(text string, enc lsp.PositionEncodingKind) int {
+	if enc == lsp.PositionEncodingUTF8 {
+		return len(text)
+	}
+	count := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		count += runeLength(r, size, enc)
+		i += size
+	}
+	return count
+}
+
+// ByteOffset returns the byte offset into line the character (counted in enc) points to.
+// Characters past the end are clamped to the length of line, a character in the middle
+// of a rune points to the start of it.
+func ByteOffset(line string, character int, enc lsp.PositionEncodingKind) int {
+	count := 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		length := runeLength(r, size, enc)
+		if count+length > character {
+			return i
+		}
+		count += length
+		i += size
+	}
+	return len(line)
+}
+
+// SetPositionEncoding sets the encoding all positions of this session are counted in.
+// It has to be set before the first document is opened, positions are not converted afterwards.
+func (s *State) SetPositionEncoding(enc lsp.PositionEncodingKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoding = enc
+}
+
+func (s *State) PositionEncoding() lsp.PositionEncodingKind {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.positionEncoding()
+}
+
+// positionEncoding expects the caller to hold the lock.
+func (s *State) positionEncoding() lsp.PositionEncodingKind {
+	if s.encoding == "" {
+		return lsp.PositionEncodingUTF16
+	}
+	return s.encoding
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"testing"
+
+	"sclls/lsp"
+)
+
+func TestNegotiatePositionEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  []lsp.PositionEncodingKind
+		expected lsp.PositionEncodingKind
+	}{
+		{"nothing offered", nil, lsp.PositionEncodingUTF16},
+		{"first supported wins", []lsp.PositionEncodingKind{"utf-7", lsp.PositionEncodingUTF32, lsp.PositionEncodingUTF8}, lsp.PositionEncodingUTF32},
+		{"utf-8", []lsp.PositionEncodingKind{lsp.PositionEncodingUTF8, lsp.PositionEncodingUTF16}, lsp.PositionEncodingUTF8},
+		{"only unknown", []lsp.PositionEncodingKind{"ebcdic"}, lsp.PositionEncodingUTF16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiatePositionEncoding(tt.offered); got != tt.expected {
+				t.Errorf("NegotiatePositionEncoding() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCharacterCountAndByteOffset(t *testing.T) {
+	// 'ä' is 2 bytes and 1 UTF-16 unit, '😀' is 4 bytes and 2 UTF-16 units
+	line := "äb😀c"
+	tests := []struct {
+		enc        lsp.PositionEncodingKind
+		count      int
+		characters []int
+		offsets    []int
+	}{
+		{lsp.PositionEncodingUTF8, 8, []int{0, 2, 3, 7, 8, 20}, []int{0, 2, 3, 7, 8, 8}},
+		{lsp.PositionEncodingUTF16, 5, []int{0, 1, 2, 3, 4, 5, 20}, []int{0, 2, 3, 3, 7, 8, 8}},
+		{lsp.PositionEncodingUTF32, 4, []int{0, 1, 2, 3, 4, -1}, []int{0, 2, 3, 7, 8, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.enc), func(t *testing.T) {
+			if got := CharacterCount(line, tt.enc); got != tt.count {
+				t.Errorf("CharacterCount() = %d, want %d", got, tt.count)
+			}
+			for i, character := range tt.characters {
+				if got := ByteOffset(line, character, tt.enc); got != tt.offsets[i] {
+					t.Errorf("ByteOffset(%d) = %d, want %d", character, got, tt.offsets[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPositionsWithNonASCII(t *testing.T) {
+	content := "# Größe 😀\n# req-Id: 😀 REQ_001, MISSING"
+	tests := []struct {
+		enc          lsp.PositionEncodingKind
+		needStart    int
+		missingStart int
+	}{
+		{lsp.PositionEncodingUTF8, 15, 24},
+		{lsp.PositionEncodingUTF16, 13, 22},
+		{lsp.PositionEncodingUTF32, 12, 21},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.enc), func(t *testing.T) {
+			state := createTestState()
+			state.SetPositionEncoding(tt.enc)
+			diagnostics := state.OpenDocument("file:///test.py", content)
+
+			// The emoji is no need, so the whole part is reported as unknown
+			var missing *lsp.Diagnostic
+			for i := range diagnostics {
+				if diagnostics[i].Range.Start.Character == tt.missingStart {
+					missing = &diagnostics[i]
+				}
+			}
+			if missing == nil || missing.Range.End.Character != tt.missingStart+len("MISSING") {
+				t.Errorf("Expected a diagnostic for MISSING at %d, got %+v", tt.missingStart, diagnostics)
+			}
+
+			need, err := state.FindNeedsInRequestedPosition("file:///test.py", lsp.Position{Line: 1, Character: tt.needStart + 2})
+			if err != nil || need.ID != "REQ_001" {
+				t.Errorf("Expected REQ_001 at character %d, got %q (err %v)", tt.needStart+2, need.ID, err)
+			}
+			positions := state.Documents["file:///test.py"].Needs[0].Positions
+			if positions[0].StartCol != tt.needStart || positions[0].EndCol != tt.needStart+len("REQ_001") {
+				t.Errorf("REQ_001 found at %+v, want start %d", positions[0], tt.needStart)
+			}
+
+			// Replacing the need behind the emoji has to hit the right bytes
+			if err := state.ChangeDocument("file:///test.py", 2, []lsp.TextDocumentContentChangeEvent{change(1, tt.needStart, 1, tt.needStart+7, "REQ_002")}); err != nil {
+				t.Fatal(err)
+			}
+			if expected := "# Größe 😀\n# req-Id: 😀 REQ_002, MISSING"; state.Documents["file:///test.py"].Content != expected {
+				t.Errorf("Content = %q, want %q", state.Documents["file:///test.py"].Content, expected)
+			}
+		})
+	}
+}
@@ -1,10 +1,9 @@
 package internal
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"path/filepath"
 
@@ -13,8 +12,17 @@ import (
 
 type DocumentInfo struct {
 	Content string
+	// Version the client gave the content, nil if it is unknown. 0 is a version like any other.
+	Version *int
 	DocumentNeeds
 	Diagnostics []lsp.Diagnostic
+
+	// Line index of Content, always set together with it via setContent
+	doc *Document
+	// Counts every change of the content, also those without a version
+	revision int
+	// Diagnostics do not match the content anymore and have to be computed again
+	diagnosticsStale bool
 }
 
 type DocumentNeeds struct {
@@ -29,9 +37,10 @@ type NeedDocInfo struct {
 }
 
 type NeedPositionInfo struct {
-	Line     int `json:"line"`
-	StartCol int `json:"startCol"`
-	EndCol   int `json:"endCol"`
+	Line     int           `json:"line"`
+	StartCol int           `json:"startCol"`
+	EndCol   int           `json:"endCol"`
+	Kind     ReferenceKind `json:"kind,omitempty"`
 }
 
 func GetDocumentNameFromURI(uri string) (string, error) {
@@ -43,6 +52,18 @@ func GetDocumentNameFromURI(uri string) (string, error) {
 	return filepath.Base(parsed.Path), nil
 }
 
+// GetPathFromURI returns the local path of a file:// URI.
+func GetPathFromURI(uri string) (string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "file" {
+		return "", fmt.Errorf("can only read file URIs, got %q", uri)
+	}
+	return filepath.FromSlash(parsed.Path), nil
+}
+
 func GetURIFromDocumentName(filename string, docPath string) string {
 	// Make sure this isn't double-encoding the path
 	absPath, err := filepath.Abs(docPath + "/" + filename)
@@ -62,81 +83,32 @@ func GetURIFromDocumentName(filename string, docPath string) string {
 	return fileURI.String()
 }
 
-// Helper I guess?
-func FindAllNeedsPositions(content []byte, toBeSearchedNeeds NeedsInfo) []NeedDocInfo {
-	var result []NeedDocInfo
-	// Search for each string one by one
-	for id, need := range toBeSearchedNeeds {
-		var ndi NeedDocInfo
-		positions := FindNeedPoisiton(content, id)
-		if len(positions) == 0 {
-			// No positons were found for this need
-			continue
-		}
-		ndi.Positions = positions
-		ndi.Need = need
-		result = append(result, ndi)
-	}
-	return result
+// FindAllNeedsPositions finds all references to the needs the matcher knows in doc.
+// The columns of the positions are counted in enc.
+func FindAllNeedsPositions(doc *Document, matcher *NeedMatcher, detector *NeedDetector, enc lsp.PositionEncodingKind) []NeedDocInfo {
+	return detector.Find(doc, matcher, enc)
 }
 
-// FindString searches for one string and returns all its positions
-func FindNeedPoisiton(content []byte, needID string) []NeedPositionInfo {
-	var positions []NeedPositionInfo
-	searchBytes := []byte(needID)
-
-	// Keep looking until we can't find any more matches
-	start := 0
-	for {
-
-		index := bytes.Index(content[start:], searchBytes)
-		if index == -1 {
-			break // No more matches found
-		}
-
-		// Calculate the actual position in the full content
-		actualNeedPositionInfo := start + index
-
-		// TODO: Easier way to do this?
-		line, col := getLineAndColumn(content, actualNeedPositionInfo)
-
-		// Save this match
-		positions = append(positions, NeedPositionInfo{
-			Line:     line,
-			StartCol: col,
-			EndCol:   col + len(needID),
-		})
-
-		// Move past this match to look for the next one
-		start = actualNeedPositionInfo + 1
-	}
-
-	return positions
+// setContent replaces the content and its line index.
+func (di *DocumentInfo) setContent(content string) {
+	di.Content = content
+	di.doc = NewDocument(content)
 }
 
-// getLineAndColumn figures out what line and column a position is at
-func getLineAndColumn(content []byte, position int) (int, int) {
-	line := 0
-	col := 0
-
-	// Go through the content character by character until we reach our position
-	for i := 0; i < position && i < len(content); i++ {
-		if content[i] == '\n' {
-			line++  // New line found
-			col = 0 // Reset column to start of new line
-		} else {
-			col++ // Move to next column
-		}
+// document returns the line index of Content.
+func (di *DocumentInfo) document() *Document {
+	if di.doc == nil {
+		// DocumentInfo was not filled in via setContent. Not cached, we may only hold the read lock.
+		return NewDocument(di.Content)
 	}
-
-	return line, col
+	return di.doc
 }
 
 // TODO: Return error?
-func NewDocumentNeeds(uri string, logger *log.Logger) DocumentNeeds {
+func NewDocumentNeeds(uri string, logger *slog.Logger) DocumentNeeds {
 	docName, err := GetDocumentNameFromURI(uri)
 	if err != nil {
-		logger.Printf("could not convert URI to document name. URI: %s Error: %s", uri, err.Error())
+		logger.Warn("could not convert URI to document name", "uri", uri, "err", err)
 	}
 	return DocumentNeeds{
 		DocName: docName,
@@ -145,7 +117,6 @@ func NewDocumentNeeds(uri string, logger *log.Logger) DocumentNeeds {
 }
 
 func (di DocumentInfo) FindNeedsInPosition(pos lsp.Position) (Need, error) {
-	fmt.Println("INSIDE Find NEEDS POSITION")
 	for _, need := range di.Needs {
 		for _, p := range need.Positions {
 			if pos.Line == p.Line && pos.Character >= p.StartCol && pos.Character <= p.EndCol {
@@ -1,7 +1,7 @@
 package internal
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"sclls/lsp"
 	"testing"
@@ -95,21 +95,26 @@ func TestFindNeedPosition(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := FindNeedPoisiton(tt.content, tt.needID)
+			detector, _ := NewNeedDetector("", nil)
+			matcher := NewNeedMatcher(NeedsInfo{tt.needID: {ID: tt.needID}})
+			var got []NeedPositionInfo
+			for _, found := range detector.Find(NewDocument(string(tt.content)), matcher, lsp.PositionEncodingUTF16) {
+				got = append(got, found.Positions...)
+			}
 			if len(got) != len(tt.want) {
-				t.Errorf("FindNeedPoisiton() returned %d results, want %d", len(got), len(tt.want))
+				t.Errorf("Find() returned %d results, want %d", len(got), len(tt.want))
 				return
 			}
 			for i, pos := range got {
 				if pos.Line != tt.want[i].Line || pos.StartCol != tt.want[i].StartCol || pos.EndCol != tt.want[i].EndCol {
-					t.Errorf("FindNeedPoisiton()[%d] = %+v, want %+v", i, pos, tt.want[i])
+					t.Errorf("Find()[%d] = %+v, want %+v", i, pos, tt.want[i])
 				}
 			}
 		})
 	}
 }
 
-func TestGetLineAndColumn(t *testing.T) {
+func TestDocumentPosition(t *testing.T) {
 	tests := []struct {
 		name     string
 		content  []byte
@@ -149,12 +154,12 @@ func TestGetLineAndColumn(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotLine, gotCol := getLineAndColumn(tt.content, tt.position)
-			if gotLine != tt.wantLine {
-				t.Errorf("getLineAndColumn() line = %v, want %v", gotLine, tt.wantLine)
+			pos := NewDocument(string(tt.content)).Position(tt.position, lsp.PositionEncodingUTF16)
+			if pos.Line != tt.wantLine {
+				t.Errorf("Position() line = %v, want %v", pos.Line, tt.wantLine)
 			}
-			if gotCol != tt.wantCol {
-				t.Errorf("getLineAndColumn() col = %v, want %v", gotCol, tt.wantCol)
+			if pos.Character != tt.wantCol {
+				t.Errorf("Position() col = %v, want %v", pos.Character, tt.wantCol)
 			}
 		})
 	}
@@ -225,7 +230,7 @@ func TestDocumentInfo_FindNeedsInPosition(t *testing.T) {
 }
 
 func TestNewDocumentNeeds(t *testing.T) {
-	logger := log.New(os.Stdout, "", 0)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	tests := []struct {
 		name string
@@ -22,17 +22,19 @@ func (ss *StringSlice) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	*ss = StringSlice(splitCommaList(str))
+	return nil
+}
+
+// splitCommaList splits "a, b" into its trimmed parts, "" is an empty list.
+func splitCommaList(str string) []string {
 	if str == "" {
-		*ss = StringSlice{}
-		return nil
+		return []string{}
 	}
-
 	parts := strings.Split(str, ",")
 	result := make([]string, len(parts))
 	for i, part := range parts {
 		result[i] = strings.TrimSpace(part)
 	}
-
-	*ss = StringSlice(result)
-	return nil
+	return result
 }
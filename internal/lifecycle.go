@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"sclls/lsp"
+)
+
+// ServerStatus is the position of the server in the LSP lifecycle.
+//
+//	Uninitialized --initialize--> Initializing --initialized--> Running --shutdown--> ShutDown --exit--> Exited
+//
+// 'exit' can arrive in any status, the exit code tells if it was orderly.
+type ServerStatus int
+
+const (
+	StatusUninitialized ServerStatus = iota
+	StatusInitializing
+	StatusRunning
+	StatusShutDown
+	StatusExited
+)
+
+func (st ServerStatus) String() string {
+	switch st {
+	case StatusUninitialized:
+		return "uninitialized"
+	case StatusInitializing:
+		return "initializing"
+	case StatusRunning:
+		return "running"
+	case StatusShutDown:
+		return "shut down"
+	case StatusExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+var ErrAlreadyInitialized = errors.New("server was already initialized")
+
+func (s *State) Status() ServerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Initialize moves the server out of Uninitialized. It may only happen once.
+func (s *State) Initialize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != StatusUninitialized {
+		return ErrAlreadyInitialized
+	}
+	s.status = StatusInitializing
+	return nil
+}
+
+// Initialized marks the handshake as finished. Clients may skip the notification,
+// therefore any request after 'initialize' is accepted either way.
+func (s *State) Initialized() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == StatusInitializing {
+		s.status = StatusRunning
+	}
+}
+
+// Shutdown drops everything we hold in memory. Afterwards only 'exit' is expected.
+func (s *State) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status >= StatusShutDown {
+		return
+	}
+	s.status = StatusShutDown
+	s.Documents = make(map[string]*DocumentInfo)
+	// Only drops our reference, other sessions may still use the index
+	s.Needs = nil
+	s.Logger.Info("Shutdown requested, released documents and needs")
+}
+
+// Exit ends the lifecycle and returns the exit code the process should end with.
+// As required by the spec it is 0 only if 'shutdown' was received before.
+func (s *State) Exit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := 1
+	if s.status == StatusShutDown {
+		code = 0
+	}
+	s.status = StatusExited
+	s.exitCode = code
+	return code
+}
+
+func (s *State) ExitCode() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exitCode
+}
+
+// CheckRequest tells if a request for method may be processed in the current status.
+// A nil return means yes, otherwise the error should be send back to the client.
+func (s *State) CheckRequest(method string) *lsp.ResponseError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch s.status {
+	case StatusUninitialized:
+		if method != "initialize" {
+			return lsp.NewResponseError(lsp.ServerNotInitialized, "server not initialized, can not handle %s", method)
+		}
+	case StatusInitializing, StatusRunning:
+		if method == "initialize" {
+			return lsp.NewResponseError(lsp.InvalidRequest, "%s", ErrAlreadyInitialized.Error())
+		}
+	case StatusShutDown, StatusExited:
+		return lsp.NewResponseError(lsp.InvalidRequest, "server is shutting down, can not handle %s", method)
+	}
+	return nil
+}
+
+// AcceptsNotification tells if a notification for method should be processed.
+// Before initialization and after shutdown all notifications except 'exit' are dropped.
+func (s *State) AcceptsNotification(method string) bool {
+	if method == "exit" {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status == StatusInitializing || s.status == StatusRunning
+}
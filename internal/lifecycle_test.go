@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"sclls/lsp"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	state := createTestState()
+
+	if respErr := state.CheckRequest("textDocument/hover"); respErr == nil || respErr.Code != lsp.ServerNotInitialized {
+		t.Fatalf("Expected ServerNotInitialized before initialize, got %v", respErr)
+	}
+	if state.AcceptsNotification("textDocument/didOpen") {
+		t.Error("Expected notifications to be dropped before initialize")
+	}
+	if respErr := state.CheckRequest("initialize"); respErr != nil {
+		t.Fatalf("Expected initialize to be accepted, got %v", respErr)
+	}
+	if err := state.Initialize(); err != nil {
+		t.Fatalf("Initialize() unexpected error = %v", err)
+	}
+	if err := state.Initialize(); err == nil {
+		t.Error("Expected second Initialize() to fail")
+	}
+	if respErr := state.CheckRequest("initialize"); respErr == nil || respErr.Code != lsp.InvalidRequest {
+		t.Errorf("Expected InvalidRequest for second initialize, got %v", respErr)
+	}
+	state.Initialized()
+	if state.Status() != StatusRunning {
+		t.Fatalf("Expected status running, got %s", state.Status())
+	}
+	if respErr := state.CheckRequest("textDocument/hover"); respErr != nil {
+		t.Errorf("Expected requests to be accepted while running, got %v", respErr)
+	}
+
+	state.OpenDocument("file:///test.rst", "# req-Id: REQ_001")
+	state.Shutdown()
+	if len(state.Documents) != 0 {
+		t.Error("Expected documents to be released on shutdown")
+	}
+	if state.Needs != nil {
+		t.Error("Expected needs to be released on shutdown")
+	}
+	if respErr := state.CheckRequest("textDocument/hover"); respErr == nil || respErr.Code != lsp.InvalidRequest {
+		t.Errorf("Expected InvalidRequest after shutdown, got %v", respErr)
+	}
+	if !state.AcceptsNotification("exit") {
+		t.Error("Expected exit to be accepted after shutdown")
+	}
+	if code := state.Exit(); code != 0 {
+		t.Errorf("Expected exit code 0 after shutdown, got %d", code)
+	}
+	if state.Status() != StatusExited {
+		t.Errorf("Expected status exited, got %s", state.Status())
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	state := createTestState()
+	if err := state.Initialize(); err != nil {
+		t.Fatalf("Initialize() unexpected error = %v", err)
+	}
+	if code := state.Exit(); code != 1 {
+		t.Errorf("Expected exit code 1 without shutdown, got %d", code)
+	}
+	if state.ExitCode() != 1 {
+		t.Errorf("ExitCode() = %d, want 1", state.ExitCode())
+	}
+}
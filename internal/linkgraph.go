@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// defaultLinkTypes are used for needs whose version has no needs_schema.
+var defaultLinkTypes = []string{
+	"links", "realizes", "satisfies", "contains", "has", "input", "output", "responsible", "approved_by",
+	"supported_by", "complies", "fulfils", "implements", "uses", "includes", "included_by", "parent_needs",
+}
+
+// backlinkSuffix is how sphinx-needs names the backlink field of a link type.
+const backlinkSuffix = "_back"
+
+// Link is one edge of the graph, Type is the link field it comes from, e.g. "satisfies".
+type Link struct {
+	Type string
+	Need Need
+}
+
+// DanglingLink is a link to a need that does not exist.
+type DanglingLink struct {
+	Source string
+	Type   string
+	Target string
+}
+
+type edge struct {
+	linkType string
+	id       string
+}
+
+// LinkGraph resolves the links between needs. The link types come from the
+// fields the needs_schema marks as links, so custom link types work as well.
+// Backlinks that the needs.json does not contain are computed from the links.
+type LinkGraph struct {
+	needs     NeedsInfo
+	linkTypes []string
+	// Need ID => the needs it links to
+	outgoing map[string][]edge
+	// Need ID => the needs linking to it
+	incoming map[string][]edge
+	dangling []DanglingLink
+}
+
+func NewLinkGraph(needs NeedsInfo) *LinkGraph {
+	g := &LinkGraph{
+		needs:    needs,
+		outgoing: make(map[string][]edge),
+		incoming: make(map[string][]edge),
+	}
+	types := make(map[string]bool)
+
+	// Needs of the same version share their schema
+	fieldsBySchema := make(map[*NeedsSchema][2][]string)
+	for id, need := range needs {
+		fields, ok := fieldsBySchema[need.schema]
+		if !ok {
+			fields[0], fields[1] = linkFields(need.schema)
+			fieldsBySchema[need.schema] = fields
+		}
+		linkTypes, backlinkTypes := fields[0], fields[1]
+		for _, linkType := range linkTypes {
+			types[linkType] = true
+			for _, target := range need.StringsField(linkType) {
+				g.addLink(id, linkType, target)
+			}
+		}
+		for _, backlinkType := range backlinkTypes {
+			linkType := strings.TrimSuffix(backlinkType, backlinkSuffix)
+			types[linkType] = true
+			for _, source := range need.StringsField(backlinkType) {
+				g.addLink(source, linkType, id)
+			}
+		}
+	}
+	// The needs come in a random order, sort everything so the results do not change between builds
+	for _, edges := range [2]map[string][]edge{g.outgoing, g.incoming} {
+		for _, e := range edges {
+			slices.SortFunc(e, func(a, b edge) int {
+				if a.linkType != b.linkType {
+					return strings.Compare(a.linkType, b.linkType)
+				}
+				return strings.Compare(a.id, b.id)
+			})
+		}
+	}
+	for linkType := range types {
+		g.linkTypes = append(g.linkTypes, linkType)
+	}
+	sort.Strings(g.linkTypes)
+	sort.Slice(g.dangling, func(i, j int) bool {
+		a, b := g.dangling[i], g.dangling[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Target < b.Target
+	})
+	// Links and backlinks can both contain the same dangling link
+	g.dangling = slices.Compact(g.dangling)
+	return g
+}
+
+// linkFields returns the names of the link and backlink fields of a schema.
+func linkFields(schema *NeedsSchema) (links []string, backlinks []string) {
+	if schema == nil {
+		return defaultLinkTypes, nil
+	}
+	for name, field := range schema.Properties {
+		switch field.FieldType {
+		case "links":
+			links = append(links, name)
+		case "backlinks":
+			backlinks = append(backlinks, name)
+		}
+	}
+	sort.Strings(links)
+	sort.Strings(backlinks)
+	return links, backlinks
+}
+
+// addLink adds source --linkType--> target once, the same link can be in the links and backlinks of a need.
+func (g *LinkGraph) addLink(source, linkType, target string) {
+	target = g.resolve(target)
+	source = g.resolve(source)
+	if _, ok := g.needs[target]; !ok {
+		g.dangling = append(g.dangling, DanglingLink{Source: source, Type: linkType, Target: target})
+		return
+	}
+	if _, ok := g.needs[source]; !ok {
+		// A backlink from a need we do not know, nothing to attach it to
+		return
+	}
+	out := edge{linkType: linkType, id: target}
+	if slices.Contains(g.outgoing[source], out) {
+		return
+	}
+	g.outgoing[source] = append(g.outgoing[source], out)
+	g.incoming[target] = append(g.incoming[target], edge{linkType: linkType, id: source})
+}
+
+// resolve maps links to need parts ("REQ_1.part") to the need itself.
+func (g *LinkGraph) resolve(id string) string {
+	if _, ok := g.needs[id]; ok {
+		return id
+	}
+	if need, _, found := strings.Cut(id, "."); found {
+		if _, ok := g.needs[need]; ok {
+			return need
+		}
+	}
+	return id
+}
+
+// LinkTypes lists every link type of the needs, sorted.
+func (g *LinkGraph) LinkTypes() []string {
+	if g == nil {
+		return nil
+	}
+	return g.linkTypes
+}
+
+// Dangling lists the links to needs that do not exist.
+func (g *LinkGraph) Dangling() []DanglingLink {
+	if g == nil {
+		return nil
+	}
+	return g.dangling
+}
+
+// Links returns the needs id links to, of all link types if none are given.
+func (g *LinkGraph) Links(id string, linkTypes ...string) []Link {
+	if g == nil {
+		return nil
+	}
+	return g.resolveEdges(g.outgoing[id], linkTypes)
+}
+
+// Backlinks returns the needs linking to id, of all link types if none are given.
+func (g *LinkGraph) Backlinks(id string, linkTypes ...string) []Link {
+	if g == nil {
+		return nil
+	}
+	return g.resolveEdges(g.incoming[id], linkTypes)
+}
+
+func (g *LinkGraph) resolveEdges(edges []edge, linkTypes []string) []Link {
+	var links []Link
+	for _, e := range edges {
+		if len(linkTypes) == 0 || slices.Contains(linkTypes, e.linkType) {
+			links = append(links, Link{Type: e.linkType, Need: g.needs[e.id]})
+		}
+	}
+	return links
+}
+
+// Parents are the needs id links to, e.g. the requirements it satisfies.
+func (g *LinkGraph) Parents(id string, linkTypes ...string) []Need {
+	return needsOf(g.Links(id, linkTypes...))
+}
+
+// Children are the needs linking to id.
+func (g *LinkGraph) Children(id string, linkTypes ...string) []Need {
+	return needsOf(g.Backlinks(id, linkTypes...))
+}
+
+// Ancestors returns the transitive closure of Parents, every need once and without id itself.
+func (g *LinkGraph) Ancestors(id string, linkTypes ...string) []Need {
+	if g == nil {
+		return nil
+	}
+	return g.closure(id, g.outgoing, linkTypes)
+}
+
+// Descendants returns the transitive closure of Children, every need once and without id itself.
+func (g *LinkGraph) Descendants(id string, linkTypes ...string) []Need {
+	if g == nil {
+		return nil
+	}
+	return g.closure(id, g.incoming, linkTypes)
+}
+
+// closure walks the edges breadth first, links can form cycles.
+func (g *LinkGraph) closure(id string, edges map[string][]edge, linkTypes []string) []Need {
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	var result []Need
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range edges[current] {
+			if seen[e.id] || len(linkTypes) > 0 && !slices.Contains(linkTypes, e.linkType) {
+				continue
+			}
+			seen[e.id] = true
+			queue = append(queue, e.id)
+			result = append(result, g.needs[e.id])
+		}
+	}
+	return result
+}
+
+func needsOf(links []Link) []Need {
+	var needs []Need
+	for _, link := range links {
+		if !slices.ContainsFunc(needs, func(n Need) bool { return n.ID == link.Need.ID }) {
+			needs = append(needs, link.Need)
+		}
+	}
+	return needs
+}
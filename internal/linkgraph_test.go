@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const linkedVersionJson = `{
+  "needs": {
+    "STKH_1": {"id": "STKH_1", "satisfies_back": ["FEAT_1"]},
+    "FEAT_1": {"id": "FEAT_1", "satisfies": ["STKH_1", "STKH_GONE"]},
+    "FEAT_2": {"id": "FEAT_2", "satisfies": "STKH_1.part", "verifies_back": ["TEST_1"]},
+    "COMP_1": {"id": "COMP_1", "satisfies": ["FEAT_1", "FEAT_2"]},
+    "TEST_1": {"id": "TEST_1", "verifies": ["COMP_1"]},
+    "CYCLE_1": {"id": "CYCLE_1", "satisfies": ["CYCLE_2"]},
+    "CYCLE_2": {"id": "CYCLE_2", "satisfies": ["CYCLE_1"]}
+  },
+  "needs_schema": {
+    "properties": {
+      "satisfies": {"type": "array", "items": {"type": "string"}, "field_type": "links"},
+      "satisfies_back": {"type": "array", "items": {"type": "string"}, "field_type": "backlinks"},
+      "verifies": {"type": "array", "items": {"type": "string"}, "field_type": "links"},
+      "verifies_back": {"type": "array", "items": {"type": "string"}, "field_type": "backlinks"}
+    }
+  }
+}`
+
+func ids(needs []Need) []string {
+	var result []string
+	for _, need := range needs {
+		result = append(result, need.ID)
+	}
+	return result
+}
+
+func TestLinkGraph(t *testing.T) {
+	var version Version
+	if err := json.Unmarshal([]byte(linkedVersionJson), &version); err != nil {
+		t.Fatal(err)
+	}
+	graph := NewLinkGraph(version.NeedsInfo)
+
+	if got := graph.LinkTypes(); !reflect.DeepEqual(got, []string{"satisfies", "verifies"}) {
+		t.Errorf("LinkTypes() = %v", got)
+	}
+	wantDangling := []DanglingLink{{Source: "FEAT_1", Type: "satisfies", Target: "STKH_GONE"}}
+	if got := graph.Dangling(); !reflect.DeepEqual(got, wantDangling) {
+		t.Errorf("Dangling() = %v, want %v", got, wantDangling)
+	}
+
+	tests := []struct {
+		name string
+		got  []Need
+		want []string
+	}{
+		{name: "parents", got: graph.Parents("COMP_1"), want: []string{"FEAT_1", "FEAT_2"}},
+		{name: "parent via a need part", got: graph.Parents("FEAT_2"), want: []string{"STKH_1"}},
+		{name: "children with computed backlinks", got: graph.Children("STKH_1"), want: []string{"FEAT_1", "FEAT_2"}},
+		// Only in verifies_back of FEAT_2, TEST_1 itself does not list it
+		{name: "backlink without link", got: graph.Parents("TEST_1"), want: []string{"COMP_1", "FEAT_2"}},
+		{name: "children of one link type", got: graph.Children("FEAT_2", "verifies"), want: []string{"TEST_1"}},
+		{name: "ancestors", got: graph.Ancestors("TEST_1"), want: []string{"COMP_1", "FEAT_2", "FEAT_1", "STKH_1"}},
+		{name: "ancestors of one link type", got: graph.Ancestors("TEST_1", "verifies"), want: []string{"COMP_1", "FEAT_2"}},
+		{name: "descendants", got: graph.Descendants("STKH_1"), want: []string{"FEAT_1", "FEAT_2", "COMP_1", "TEST_1"}},
+		{name: "cycle", got: graph.Ancestors("CYCLE_1"), want: []string{"CYCLE_2"}},
+		{name: "unknown need", got: graph.Parents("MISSING"), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	links := graph.Links("FEAT_2")
+	if len(links) != 1 || links[0].Type != "satisfies" || links[0].Need.ID != "STKH_1" {
+		t.Errorf("Links() = %+v, want FEAT_2 satisfies STKH_1", links)
+	}
+}
+
+func TestLinkGraphWithoutSchema(t *testing.T) {
+	graph := NewLinkGraph(NeedsInfo{
+		"REQ_1":  {ID: "REQ_1"},
+		"IMPL_1": {ID: "IMPL_1", fields: map[string]any{"implements": []any{"REQ_1"}}},
+	})
+	if got := ids(graph.Children("REQ_1", "implements")); !reflect.DeepEqual(got, []string{"IMPL_1"}) {
+		t.Errorf("Children() = %v, want the hardcoded link types to be used", got)
+	}
+}
+
+func TestNilLinkGraph(t *testing.T) {
+	// What Graph() returns for a nil index
+	var graph *LinkGraph
+	if graph.LinkTypes() != nil || graph.Dangling() != nil {
+		t.Error("Expected a nil graph to have no link types and no dangling links")
+	}
+	if graph.Links("REQ_1") != nil || graph.Backlinks("REQ_1") != nil {
+		t.Error("Expected a nil graph to have no links")
+	}
+	if graph.Parents("REQ_1") != nil || graph.Children("REQ_1") != nil || graph.Ancestors("REQ_1") != nil || graph.Descendants("REQ_1") != nil {
+		t.Error("Expected a nil graph to have no related needs")
+	}
+}
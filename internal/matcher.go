@@ -0,0 +1,134 @@
+package internal
+
+import "sort"
+
+// NeedMatcher finds every known need ID in a document in a single pass.
+// It is an Aho-Corasick automaton over all IDs, built once whenever the needs are loaded.
+// Like searching for each ID on its own it reports all matches, also overlapping ones.
+type NeedMatcher struct {
+	needs NeedsInfo
+	ids   []string
+
+	// Bytes that appear in no ID share class 0, which always leads back to the root
+	classes    [256]uint16
+	numClasses int
+	// delta[state*numClasses+class] is the next state, every transition is resolved already
+	delta []int32
+	// Index into ids of the ID that ends in a state, -1 if none does
+	match []int32
+	// Next state on the failure chain that ends an ID, -1 if there is none
+	dictLink []int32
+}
+
+func NewNeedMatcher(needs NeedsInfo) *NeedMatcher {
+	m := &NeedMatcher{needs: needs}
+	for id := range needs {
+		if id == "" {
+			// Would match everywhere
+			continue
+		}
+		m.ids = append(m.ids, id)
+	}
+	// Map iteration is random, keep the automaton the same between builds
+	sort.Strings(m.ids)
+
+	m.numClasses = 1
+	for _, id := range m.ids {
+		for i := 0; i < len(id); i++ {
+			if m.classes[id[i]] == 0 {
+				m.classes[id[i]] = uint16(m.numClasses)
+				m.numClasses++
+			}
+		}
+	}
+
+	m.newState()
+	for i, id := range m.ids {
+		state := int32(0)
+		for j := 0; j < len(id); j++ {
+			t := int(state)*m.numClasses + int(m.classes[id[j]])
+			if m.delta[t] == -1 {
+				m.delta[t] = m.newState()
+			}
+			state = m.delta[t]
+		}
+		m.match[state] = int32(i)
+	}
+	m.link()
+	return m
+}
+
+func (m *NeedMatcher) newState() int32 {
+	for range m.numClasses {
+		m.delta = append(m.delta, -1)
+	}
+	m.match = append(m.match, -1)
+	m.dictLink = append(m.dictLink, -1)
+	return int32(len(m.match) - 1)
+}
+
+// link sets the failure transitions breadth first, so the states closer to the root are done first.
+func (m *NeedMatcher) link() {
+	fail := make([]int32, len(m.match))
+	var queue []int32
+	for c := range m.numClasses {
+		if next := m.delta[c]; next == -1 {
+			m.delta[c] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		if f := fail[state]; m.match[f] != -1 {
+			m.dictLink[state] = f
+		} else {
+			m.dictLink[state] = m.dictLink[f]
+		}
+		for c := range m.numClasses {
+			t := int(state)*m.numClasses + c
+			fallback := m.delta[int(fail[state])*m.numClasses+c]
+			if next := m.delta[t]; next == -1 {
+				m.delta[t] = fallback
+			} else {
+				fail[next] = fallback
+				queue = append(queue, next)
+			}
+		}
+	}
+}
+
+// Match is one occurrence of an ID, Start and End are byte offsets.
+type Match struct {
+	Start int
+	End   int
+	ID    string
+}
+
+// Matches returns every occurrence of every ID in content, ordered by where they end.
+// A nil matcher finds nothing.
+func (m *NeedMatcher) Matches(content string) []Match {
+	if m == nil || len(m.ids) == 0 {
+		return nil
+	}
+	var matches []Match
+	state := int32(0)
+	for i := 0; i < len(content); i++ {
+		state = m.delta[int(state)*m.numClasses+int(m.classes[content[i]])]
+		found := state
+		if m.match[found] == -1 {
+			found = m.dictLink[found]
+		}
+		for ; found != -1; found = m.dictLink[found] {
+			id := m.ids[m.match[found]]
+			matches = append(matches, Match{Start: i + 1 - len(id), End: i + 1, ID: id})
+		}
+	}
+	return matches
+}
+
+// Need returns the need with the given ID.
+func (m *NeedMatcher) Need(id string) Need {
+	return m.needs[id]
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"sclls/lsp"
+)
+
+// findAllNaive searches every ID on its own, the way it was done before the matcher.
+func findAllNaive(content string, needs NeedsInfo) []Match {
+	var matches []Match
+	for id := range needs {
+		for start := 0; ; start++ {
+			idx := strings.Index(content[start:], id)
+			if idx == -1 {
+				break
+			}
+			start += idx
+			matches = append(matches, Match{Start: start, End: start + len(id), ID: id})
+		}
+	}
+	return matches
+}
+
+func sortMatches(matches []Match) []Match {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+func TestNeedMatcher(t *testing.T) {
+	needs := NeedsInfo{
+		"REQ_1":    {ID: "REQ_1"},
+		"REQ_10":   {ID: "REQ_10"},
+		"EQ_1":     {ID: "EQ_1"},
+		"TOOL_001": {ID: "TOOL_001"},
+		"äö_1":     {ID: "äö_1"},
+		"":         {},
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    []Match
+	}{
+		{
+			name:    "nothing",
+			content: "just some text\n",
+		},
+		{
+			name:    "overlapping and nested IDs",
+			content: "# req-Id: REQ_10\nREQ_1REQ_1",
+			want: []Match{
+				{10, 15, "REQ_1"}, {10, 16, "REQ_10"}, {11, 15, "EQ_1"},
+				{17, 22, "REQ_1"}, {18, 22, "EQ_1"}, {22, 27, "REQ_1"}, {23, 27, "EQ_1"},
+			},
+		},
+		{
+			name:    "non ASCII",
+			content: "é TOOL_001 äö_1",
+			want:    []Match{{3, 11, "TOOL_001"}, {12, 18, "äö_1"}},
+		},
+	}
+
+	matcher := NewNeedMatcher(needs)
+	// Searching for "" on its own never ends, the matcher skips it
+	delete(needs, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sortMatches(matcher.Matches(tt.content))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Matches() = %+v, want %+v", got, tt.want)
+			}
+			if naive := sortMatches(findAllNaive(tt.content, needs)); !reflect.DeepEqual(got, naive) {
+				t.Errorf("Matches() = %+v, searching every ID found %+v", got, naive)
+			}
+		})
+	}
+
+	var nilMatcher *NeedMatcher
+	if got := nilMatcher.Matches("REQ_1"); got != nil {
+		t.Errorf("nil matcher found %+v", got)
+	}
+}
+
+// syntheticNeeds creates n needs and a document of lines that mention some of them.
+func syntheticNeeds(n, lines int) (NeedsInfo, *Document) {
+	rng := rand.New(rand.NewSource(1))
+	prefixes := []string{"REQ", "TOOL", "SPEC", "TEST", "FEAT"}
+	needs := make(NeedsInfo, n)
+	ids := make([]string, 0, n)
+	for i := range n {
+		id := fmt.Sprintf("%s_%05d", prefixes[i%len(prefixes)], i)
+		needs[id] = Need{ID: id}
+		ids = append(ids, id)
+	}
+	var sb strings.Builder
+	for i := range lines {
+		switch i % 3 {
+		case 0:
+			fmt.Fprintf(&sb, "# req-Id: %s\n", ids[rng.Intn(len(ids))])
+		case 1:
+			fmt.Fprintf(&sb, "def function_%d(value):  # see %s and %s\n", i, ids[rng.Intn(len(ids))], ids[rng.Intn(len(ids))])
+		default:
+			sb.WriteString("    return value * 2 if value else None\n")
+		}
+	}
+	return needs, NewDocument(sb.String())
+}
+
+func BenchmarkFindAllNeedsPositions(b *testing.B) {
+	needs, doc := syntheticNeeds(5000, 2000)
+	detector, _ := NewNeedDetector("", []string{"# req-Id: "})
+	b.Run("naive", func(b *testing.B) {
+		for b.Loop() {
+			findAllNaive(doc.Content(), needs)
+		}
+	})
+	b.Run("matcher", func(b *testing.B) {
+		matcher := NewNeedMatcher(needs)
+		for b.Loop() {
+			matcher.Matches(doc.Content())
+		}
+	})
+	b.Run("detector", func(b *testing.B) {
+		matcher := NewNeedMatcher(needs)
+		for b.Loop() {
+			FindAllNeedsPositions(doc, matcher, detector, lsp.PositionEncodingUTF16)
+		}
+	})
+}
+
+func BenchmarkNewNeedMatcher(b *testing.B) {
+	needs, _ := syntheticNeeds(5000, 0)
+	for b.Loop() {
+		NewNeedMatcher(needs)
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NeedsSchema is the needs_schema block of a version, a JSON schema describing every field a need can have.
+type NeedsSchema struct {
+	Properties map[string]FieldSchema `json:"properties"`
+}
+
+type FieldSchema struct {
+	Type        SchemaType `json:"type"`
+	Description string     `json:"description"`
+	// core, extra, links or backlinks
+	FieldType string          `json:"field_type"`
+	Default   json.RawMessage `json:"default"`
+	Items     *FieldSchema    `json:"items"`
+}
+
+// SchemaType is the JSON schema type of a field. Nullable fields are
+// written as ["string", "null"], only the type that is not null is kept.
+type SchemaType string
+
+func (st *SchemaType) UnmarshalJSON(data []byte) error {
+	var types []string
+	if err := json.Unmarshal(data, &types); err != nil {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		types = []string{single}
+	}
+	*st = ""
+	for _, t := range types {
+		if t != "null" {
+			*st = SchemaType(t)
+			break
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON fills the typed fields of Need and keeps every field of the need,
+// also those sphinx-needs projects add on their own, see Field.
+func (n *Need) UnmarshalJSON(data []byte) error {
+	// Without the methods of Need, so this does not recurse
+	type plainNeed Need
+	var plain plainNeed
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*n = Need(plain)
+	n.fields = fields
+	return nil
+}
+
+// UnmarshalJSON types the fields of all needs with the schema of the version.
+func (v *Version) UnmarshalJSON(data []byte) error {
+	type plainVersion Version
+	var plain plainVersion
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	*v = Version(plain)
+	if v.Schema == nil {
+		return nil
+	}
+	for id, need := range v.NeedsInfo {
+		need.applySchema(v.Schema)
+		v.NeedsInfo[id] = need
+	}
+	return nil
+}
+
+// applySchema converts the fields to the types of the schema, e.g. integers to int instead of float64.
+func (n *Need) applySchema(schema *NeedsSchema) {
+	n.schema = schema
+	for name, value := range n.fields {
+		if field, ok := schema.Properties[name]; ok {
+			n.fields[name] = field.convert(value)
+		}
+	}
+}
+
+// convert returns value as the type of the field, or unchanged if it does not fit.
+func (fs FieldSchema) convert(value any) any {
+	switch fs.Type {
+	case "integer":
+		if number, ok := value.(float64); ok && number == float64(int(number)) {
+			return int(number)
+		}
+		// Options of rst directives are text, see ParseRstNeeds
+		if str, ok := value.(string); ok {
+			if number, err := strconv.Atoi(strings.TrimSpace(str)); err == nil {
+				return number
+			}
+		}
+	case "boolean":
+		if str, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(strings.TrimSpace(str)); err == nil {
+				return b
+			}
+		}
+	case "array":
+		if fs.Items == nil || fs.Items.Type != "string" {
+			return value
+		}
+		switch v := value.(type) {
+		case []any:
+			strs := make([]string, 0, len(v))
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return value
+				}
+				strs = append(strs, str)
+			}
+			return strs
+		case string:
+			// Like StringSlice, some projects write lists as "a, b"
+			return splitCommaList(v)
+		}
+	}
+	return value
+}
+
+// Field returns the value of any field of the need, typed as the schema of its version says:
+// string, int, float64, bool, []string, or whatever JSON decodes to for fields without a schema.
+// Fields that are missing get the default of the schema, sphinx-needs leaves them out to save space.
+func (n Need) Field(name string) (any, bool) {
+	if value, ok := n.fields[name]; ok {
+		return value, true
+	}
+	if n.schema == nil {
+		return nil, false
+	}
+	field, ok := n.schema.Properties[name]
+	if !ok || len(field.Default) == 0 {
+		return nil, false
+	}
+	var value any
+	if err := json.Unmarshal(field.Default, &value); err != nil {
+		return nil, false
+	}
+	return field.convert(value), true
+}
+
+// StringField returns a field formatted as text, lists are joined with ", ".
+func (n Need) StringField(name string) string {
+	value, ok := n.Field(name)
+	if !ok || value == nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+// StringsField returns a list field, a single string is split at commas.
+func (n Need) StringsField(name string) []string {
+	value, ok := n.Field(name)
+	if !ok {
+		return nil
+	}
+	strs, _ := stringList.convert(value).([]string)
+	return strs
+}
+
+var stringList = FieldSchema{Type: "array", Items: &FieldSchema{Type: "string"}}
+
+// FieldNames lists the fields the need has in the needs.json, sorted.
+func (n Need) FieldNames() []string {
+	return slices.Sorted(maps.Keys(n.fields))
+}
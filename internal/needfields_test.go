@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const schemaVersionJson = `{
+  "needs": {
+    "REQ_1": {
+      "id": "REQ_1",
+      "lineno": 38,
+      "reqtype": "Process",
+      "sections": ["Example", "Intro"],
+      "has_dead_links": true,
+      "tags": "a, b",
+      "my_option": "custom",
+      "unknown": {"nested": 1}
+    }
+  },
+  "needs_schema": {
+    "properties": {
+      "lineno": {"type": ["integer", "null"], "default": null},
+      "reqtype": {"type": "string", "default": "", "field_type": "extra"},
+      "sections": {"type": "array", "items": {"type": "string"}, "default": []},
+      "has_dead_links": {"type": "boolean", "default": false},
+      "tags": {"type": "array", "items": {"type": "string"}, "default": []},
+      "my_option": {"type": "string", "default": "", "field_type": "extra"},
+      "safety": {"type": "string", "default": "QM", "field_type": "extra"},
+      "modifications": {"type": "integer", "default": 0}
+    }
+  }
+}`
+
+func TestNeedFields(t *testing.T) {
+	var version Version
+	if err := json.Unmarshal([]byte(schemaVersionJson), &version); err != nil {
+		t.Fatal(err)
+	}
+	need := version.NeedsInfo["REQ_1"]
+	if need.ReqType != "Process" || need.Lineno != 38 || !reflect.DeepEqual([]string(need.Tags), []string{"a", "b"}) {
+		t.Errorf("typed fields = %q, %d, %v, want them filled in", need.ReqType, need.Lineno, need.Tags)
+	}
+
+	tests := []struct {
+		field  string
+		want   any
+		wantOk bool
+	}{
+		{field: "lineno", want: 38, wantOk: true},
+		{field: "sections", want: []string{"Example", "Intro"}, wantOk: true},
+		{field: "has_dead_links", want: true, wantOk: true},
+		{field: "tags", want: []string{"a", "b"}, wantOk: true},
+		{field: "my_option", want: "custom", wantOk: true},
+		{field: "unknown", want: map[string]any{"nested": float64(1)}, wantOk: true},
+		// Left out by sphinx-needs because it has the default value
+		{field: "safety", want: "QM", wantOk: true},
+		{field: "modifications", want: 0, wantOk: true},
+		{field: "missing", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, ok := need.Field(tt.field)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Field(%q) = %#v, %v, want %#v, %v", tt.field, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	if got := need.StringField("sections"); got != "Example, Intro" {
+		t.Errorf("StringField() = %q, want the sections joined", got)
+	}
+	if got := need.StringsField("sections"); !reflect.DeepEqual(got, []string{"Example", "Intro"}) {
+		t.Errorf("StringsField() = %v", got)
+	}
+	if got := strings.Join(need.FieldNames(), ","); got != "has_dead_links,id,lineno,my_option,reqtype,sections,tags,unknown" {
+		t.Errorf("FieldNames() = %s", got)
+	}
+	hover := need.GenerateHoverInfo([]string{"my_option", "safety", "missing"})
+	if !strings.Contains(hover, "my_option: custom\nsafety: QM\n") || strings.Contains(hover, "missing") {
+		t.Errorf("GenerateHoverInfo() = %q, want the extra fields", hover)
+	}
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fetchTimeout keeps the server from hanging on startup if the docs site does not answer.
+const fetchTimeout = 30 * time.Second
+
+// NeedsFetcher downloads needs.json files from URLs and keeps them in CacheDir,
+// so they are still there when we are offline. Downloads are revalidated with
+// the ETag and Last-Modified of the cached copy, unchanged files are not downloaded again.
+type NeedsFetcher struct {
+	Client   *http.Client
+	CacheDir string
+}
+
+// cacheMeta is stored next to a cached needs.json.
+type cacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// NewNeedsFetcher returns the fetcher for the URL sources of config.
+func NewNeedsFetcher(config ServerConfig) NeedsFetcher {
+	return NeedsFetcher{Client: &http.Client{Timeout: fetchTimeout}, CacheDir: config.needsCacheDir()}
+}
+
+// needsCacheDir is NeedsCacheDir, or sclls in the cache folder of the user if it is empty.
+func (c ServerConfig) needsCacheDir() string {
+	if c.NeedsCacheDir != "" {
+		return c.NeedsCacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sclls")
+}
+
+// Fetch returns the needs.json at url and if it changed since it was cached.
+// If it can not be downloaded the cached copy is used, an error is only returned without one.
+func (f NeedsFetcher) Fetch(url string, logger *slog.Logger) (data []byte, changed bool, err error) {
+	dataPath, metaPath := f.cachePaths(url)
+	cached, meta, cacheErr := f.readCache(url, dataPath, metaPath)
+	data, meta, err = f.download(url, meta, cacheErr == nil)
+	switch {
+	case err != nil && cacheErr != nil:
+		return nil, false, err
+	case err != nil:
+		logger.Warn("Using the cached needs.json, could not fetch it", "url", url, "err", err)
+		return cached, false, nil
+	case data == nil:
+		// Not modified
+		return cached, false, nil
+	}
+	if err := f.writeCache(dataPath, metaPath, data, meta); err != nil {
+		logger.Warn("Could not cache the needs.json", "url", url, "err", err)
+	}
+	return data, true, nil
+}
+
+// Cached returns the copy of the needs.json at url from the cache, without going to the network.
+func (f NeedsFetcher) Cached(url string) ([]byte, error) {
+	dataPath, metaPath := f.cachePaths(url)
+	data, _, err := f.readCache(url, dataPath, metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("needs.json was not fetched yet: %w", err)
+	}
+	return data, nil
+}
+
+// download requests url, conditional if there is a cached copy. It returns nil data if that is still current.
+func (f NeedsFetcher) download(url string, meta cacheMeta, cached bool) ([]byte, cacheMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, meta, err
+	}
+	if cached && meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if cached && meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, meta, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return nil, meta, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, meta, fmt.Errorf("could not fetch needs.json: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, meta, fmt.Errorf("could not fetch needs.json: %w", err)
+	}
+	// An error page or a cut off download must not replace a good cached copy
+	if !json.Valid(data) {
+		return nil, meta, errors.New("could not fetch needs.json: the response is not JSON")
+	}
+	return data, cacheMeta{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// cachePaths names the cache files after a hash of the URL, URLs are not valid file names.
+func (f NeedsFetcher) cachePaths(url string) (dataPath, metaPath string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:16])
+	return filepath.Join(f.CacheDir, name+".json"), filepath.Join(f.CacheDir, name+".meta.json")
+}
+
+func (f NeedsFetcher) readCache(url, dataPath, metaPath string) ([]byte, cacheMeta, error) {
+	var meta cacheMeta
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, meta, err
+	}
+	// Without the meta data the copy can still be used offline, it is just not revalidated
+	if metaFile, err := os.ReadFile(metaPath); err == nil {
+		if json.Unmarshal(metaFile, &meta) != nil || meta.URL != url {
+			meta = cacheMeta{}
+		}
+	}
+	return data, meta, nil
+}
+
+// writeCache replaces the cached copy, the needs.json first so the meta data never describes an older one.
+func (f NeedsFetcher) writeCache(dataPath, metaPath string, data []byte, meta cacheMeta) error {
+	if err := os.MkdirAll(f.CacheDir, 0o755); err != nil {
+		return err
+	}
+	metaFile, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dataPath, data); err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath, metaFile)
+}
+
+// writeFileAtomic writes to a temporary file first, so other servers sharing the cache never read half a file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// FetchRemoteSources revalidates the needs.json of every URL source of config,
+// it reports if one of them changed and the needs should be reloaded.
+func FetchRemoteSources(config ServerConfig, logger *slog.Logger) (changed bool, err error) {
+	fetcher := NewNeedsFetcher(config)
+	var errs []error
+	for _, source := range config.Sources() {
+		if source.URL == "" {
+			continue
+		}
+		_, sourceChanged, err := fetcher.Fetch(source.URL, logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.location(), err))
+		}
+		changed = changed || sourceChanged
+	}
+	return changed, errors.Join(errs...)
+}
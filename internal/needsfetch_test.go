@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// needsServer serves a needs.json with an ETag and answers conditional requests like a docs site.
+type needsServer struct {
+	mu          sync.Mutex
+	content     string
+	etag        string
+	requests    int
+	notModified int
+}
+
+func (ns *needsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.requests++
+	if r.Header.Get("If-None-Match") == ns.etag {
+		ns.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", ns.etag)
+	io.WriteString(w, ns.content)
+}
+
+func (ns *needsServer) set(content, etag string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.content, ns.etag = content, etag
+}
+
+func TestNeedsFetcher(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := &needsServer{}
+	handler.set(`{"current_version": "1", "versions": {"1": {"needs": {"REQ_1": {"id": "REQ_1"}}}}}`, `"v1"`)
+	server := httptest.NewServer(handler)
+	config := ServerConfig{
+		NeedsCacheDir: t.TempDir(),
+		NeedsSources:  []NeedsSource{{URL: server.URL + "/needs.json", Name: "docs"}},
+	}
+
+	index := LoadNeedsIndex(config, logger)
+	if len(index.Needs()) != 1 || index.Needs()["REQ_1"].Source().Name != "docs" {
+		t.Fatalf("LoadNeedsIndex() = %v, want REQ_1 from the URL", index.Needs())
+	}
+	// Cached now, starting again must not wait for the server
+	requests := handler.requests
+	if got := LoadNeedsIndex(config, logger); len(got.Needs()) != 1 || handler.requests != requests {
+		t.Errorf("LoadNeedsIndex() with a cached copy = %v after %d requests, want it from the cache", got.Needs(), handler.requests-requests)
+	}
+	if sources := (ServerConfig{NeedsJsonPath: server.URL}).Sources(); sources[0].URL != server.URL || sources[0].Path != "" {
+		t.Errorf("Sources() = %+v, want --needsPath to be fetched if it is a URL", sources[0])
+	}
+
+	changed, err := FetchRemoteSources(config, logger)
+	if err != nil || changed || handler.notModified != 1 {
+		t.Errorf("FetchRemoteSources() = %v, %v with %d not modified, want it revalidated and unchanged", changed, err, handler.notModified)
+	}
+
+	handler.set(`{"current_version": "1", "versions": {"1": {"needs": {"REQ_1": {"id": "REQ_1"}, "REQ_2": {"id": "REQ_2"}}}}}`, `"v2"`)
+	if changed, err := FetchRemoteSources(config, logger); err != nil || !changed {
+		t.Errorf("FetchRemoteSources() = %v, %v, want the new version", changed, err)
+	}
+	requests = handler.requests
+	if err := index.Reload(config, logger); err != nil || len(index.Needs()) != 2 {
+		t.Errorf("Reload() error = %v with %d needs, want 2", err, len(index.Needs()))
+	}
+	if handler.requests != requests {
+		t.Errorf("Reload() sent %d requests, want it to use the cache", handler.requests-requests)
+	}
+
+	// An error page must not replace the cached copy
+	handler.set(`<html>Maintenance</html>`, `"v3"`)
+	if changed, err := FetchRemoteSources(config, logger); err != nil || changed {
+		t.Errorf("FetchRemoteSources() = %v, %v, want the cached copy to stay", changed, err)
+	}
+	if err := index.Reload(config, logger); err != nil || len(index.Needs()) != 2 {
+		t.Errorf("Reload() error = %v with %d needs, want the cached needs", err, len(index.Needs()))
+	}
+
+	// Offline, the cache has to do
+	server.Close()
+	if got := LoadNeedsIndex(config, logger); len(got.Needs()) != 2 {
+		t.Errorf("LoadNeedsIndex() offline = %v, want the cached needs", got.Needs())
+	}
+	config.NeedsCacheDir = t.TempDir()
+	if err := index.Reload(config, logger); err == nil || len(index.Needs()) != 2 {
+		t.Errorf("Reload() without cache error = %v, want an error and the previous needs", err)
+	}
+}
+
+func TestNeedsFetcherLastModified(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	var ifModifiedSince []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifModifiedSince = append(ifModifiedSince, r.Header.Get("If-Modified-Since"))
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		io.WriteString(w, `{"versions": {}}`)
+	}))
+	defer server.Close()
+
+	fetcher := NeedsFetcher{Client: server.Client(), CacheDir: t.TempDir()}
+	for i, wantChanged := range []bool{true, false} {
+		data, changed, err := fetcher.Fetch(server.URL, logger)
+		if err != nil || changed != wantChanged || string(data) != `{"versions": {}}` {
+			t.Errorf("Fetch() #%d = %q, %v, %v, want changed %v", i, data, changed, err, wantChanged)
+		}
+	}
+	if len(ifModifiedSince) != 2 || ifModifiedSince[0] != "" || ifModifiedSince[1] != lastModified {
+		t.Errorf("If-Modified-Since headers = %q, want none and then the cached Last-Modified", ifModifiedSince)
+	}
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// NeedsIndex holds the needs known to the server.
+// It is loaded once and shared by all sessions, each session only keeps its own documents.
+type NeedsIndex struct {
+	mu      sync.RWMutex
+	needs   NeedsInfo
+	matcher *NeedMatcher
+	graph   *LinkGraph
+
+	// Only one Reload at a time, so an older file can not overwrite a newer one
+	reloadMu sync.Mutex
+	// The needs of every source by its location, as they were last loaded. Guarded by reloadMu.
+	loaded map[string]NeedsInfo
+	// Called after every Update, see OnUpdate
+	listeners      map[int]func()
+	nextListenerID int
+}
+
+func NewNeedsIndex(needs NeedsInfo) *NeedsIndex {
+	return &NeedsIndex{needs: needs, matcher: NewNeedMatcher(needs), graph: NewLinkGraph(needs)}
+}
+
+// LoadNeedsIndex parses every needs.json of the config into a new index,
+// with the versions the config asks for. See ServerConfig.Sources.
+// Those from URLs come from the cache, so an unreachable server does not hold up the start.
+// It only waits for the ones that were never fetched, FetchRemoteSources revalidates the others.
+func LoadNeedsIndex(config ServerConfig, logger *slog.Logger) *NeedsIndex {
+	loaded, errs := loadSources(config, nil, true, logger)
+	if len(errs) > 0 {
+		logger.Error("Starting without the needs of some sources", "err", errors.Join(errs...))
+	}
+	index := NewNeedsIndex(mergeSources(config, loaded, logger))
+	index.loaded = loaded
+	logDanglingLinks(index.graph, logger)
+	return index
+}
+
+// Reload parses the needs.json files of the config again and swaps them in.
+// Those from URLs are read from the cache, see FetchRemoteSources.
+// A source that can not be parsed keeps its current needs, its error is returned.
+// If none of them can be parsed nothing changes, neither does it for a nil index.
+func (ni *NeedsIndex) Reload(config ServerConfig, logger *slog.Logger) error {
+	if ni == nil {
+		return nil
+	}
+	ni.reloadMu.Lock()
+	defer ni.reloadMu.Unlock()
+	loaded, errs := loadSources(config, ni.loaded, false, logger)
+	if len(errs) > 0 && len(errs) == len(config.Sources()) {
+		return errors.Join(errs...)
+	}
+	ni.loaded = loaded
+	ni.Update(mergeSources(config, loaded, logger))
+	logDanglingLinks(ni.Graph(), logger)
+	return errors.Join(errs...)
+}
+
+// ReloadRst finds the needs in the rst files again and puts them over the needs.json files
+// as they were loaded last, those are not read again. See ServerConfig.RstNeeds. A nil index is left alone.
+func (ni *NeedsIndex) ReloadRst(config ServerConfig, logger *slog.Logger) {
+	if ni == nil {
+		return
+	}
+	ni.reloadMu.Lock()
+	defer ni.reloadMu.Unlock()
+	ni.Update(mergeSources(config, ni.loaded, logger))
+	logDanglingLinks(ni.Graph(), logger)
+}
+
+func logDanglingLinks(graph *LinkGraph, logger *slog.Logger) {
+	dangling := graph.Dangling()
+	if len(dangling) == 0 {
+		return
+	}
+	logger.Info("Some needs link to needs that do not exist", "count", len(dangling))
+	for _, link := range dangling {
+		logger.Debug("Dangling link", "source", link.Source, "type", link.Type, "target", link.Target)
+	}
+}
+
+// Needs returns the current needs. The map must not be modified, it is shared.
+// A nil index has no needs.
+func (ni *NeedsIndex) Needs() NeedsInfo {
+	if ni == nil {
+		return nil
+	}
+	ni.mu.RLock()
+	defer ni.mu.RUnlock()
+	return ni.needs
+}
+
+// Matcher returns the matcher built for the current needs, nil for a nil index.
+func (ni *NeedsIndex) Matcher() *NeedMatcher {
+	if ni == nil {
+		return nil
+	}
+	ni.mu.RLock()
+	defer ni.mu.RUnlock()
+	return ni.matcher
+}
+
+// Graph returns the links between the current needs, nil for a nil index.
+func (ni *NeedsIndex) Graph() *LinkGraph {
+	if ni == nil {
+		return nil
+	}
+	ni.mu.RLock()
+	defer ni.mu.RUnlock()
+	return ni.graph
+}
+
+// Update replaces the needs for every session using the index.
+func (ni *NeedsIndex) Update(needs NeedsInfo) {
+	// Building these takes a while for many needs, readers keep the old ones until then
+	matcher := NewNeedMatcher(needs)
+	graph := NewLinkGraph(needs)
+	ni.mu.Lock()
+	ni.needs = needs
+	ni.matcher = matcher
+	ni.graph = graph
+	listeners := slices.Collect(maps.Values(ni.listeners))
+	ni.mu.Unlock()
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+// OnUpdate calls listener after every Update, until the returned function is called.
+// A nil index never changes.
+func (ni *NeedsIndex) OnUpdate(listener func()) (remove func()) {
+	if ni == nil {
+		return func() {}
+	}
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	if ni.listeners == nil {
+		ni.listeners = make(map[int]func())
+	}
+	id := ni.nextListenerID
+	ni.nextListenerID++
+	ni.listeners[id] = listener
+	return func() {
+		ni.mu.Lock()
+		defer ni.mu.Unlock()
+		delete(ni.listeners, id)
+	}
+}
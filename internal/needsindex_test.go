@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNeedsIndexReload(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	config := ServerConfig{NeedsJsonPath: filepath.Join(t.TempDir(), "needs.json")}
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {"REQ_1": {"id": "REQ_1"}}}}}`)
+	index := LoadNeedsIndex(config, logger)
+	if len(index.Needs()) != 1 {
+		t.Fatalf("LoadNeedsIndex() loaded %d needs, want 1", len(index.Needs()))
+	}
+	updates := 0
+	remove := index.OnUpdate(func() { updates++ })
+
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {"REQ_1": {"id": "REQ_1"}, "REQ_2": {"id": "REQ_2"}}}}}`)
+	if err := index.Reload(config, logger); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(index.Needs()) != 2 || len(index.Matcher().Matches("REQ_2")) != 1 || updates != 1 {
+		t.Errorf("Reload() left %d needs and called the listener %d times, want 2 needs and 1 call", len(index.Needs()), updates)
+	}
+
+	// sphinx-build is still writing it
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {"REQ_3"`)
+	if err := index.Reload(config, logger); err == nil {
+		t.Error("Reload() accepted a broken needs.json")
+	}
+	if len(index.Needs()) != 2 || updates != 1 {
+		t.Errorf("A failed Reload() changed the index to %d needs", len(index.Needs()))
+	}
+
+	// Written for another version, nothing to use
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "2", "versions": {"2": {"needs": {}}}}`)
+	config.NeedsVersion = "1"
+	if err := index.Reload(config, logger); err == nil || len(index.Needs()) != 2 || updates != 1 {
+		t.Errorf("Reload() without the version error = %v, changed the index to %d needs", err, len(index.Needs()))
+	}
+	config.NeedsVersion = ""
+
+	remove()
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {}}}}`)
+	if err := index.Reload(config, logger); err != nil || updates != 1 {
+		t.Errorf("Reload() error = %v, listener was called %d times after it was removed", err, updates)
+	}
+
+	// What a session has after shutdown
+	var none *NeedsIndex
+	if err := none.Reload(config, logger); err != nil {
+		t.Errorf("Reload() of a nil index error = %v", err)
+	}
+	none.ReloadRst(config, logger)
+}
+
+func TestRefreshNeeds(t *testing.T) {
+	state := createTestState()
+	uri := "file:///test.py"
+	if diagnostics := state.OpenDocument(uri, "# req-Id: REQ_NEW"); len(diagnostics) != 1 {
+		t.Fatalf("Expected REQ_NEW to be unknown, got %v", diagnostics)
+	}
+	state.Needs.Update(NeedsInfo{"REQ_NEW": {ID: "REQ_NEW"}})
+	if uris := state.RefreshNeeds(); len(uris) != 1 || uris[0] != uri {
+		t.Errorf("RefreshNeeds() = %v, want %s", uris, uri)
+	}
+	if len(state.Documents[uri].Needs) != 1 {
+		t.Errorf("Expected REQ_NEW to be found after the refresh, got %+v", state.Documents[uri].Needs)
+	}
+	if diagnostics, _, err := state.Diagnostics(t.Context(), uri); err != nil || len(diagnostics) != 0 {
+		t.Errorf("Diagnostics() = %v (err %v), want none for the new need", diagnostics, err)
+	}
+}
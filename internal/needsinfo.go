@@ -13,6 +13,8 @@ type Creator struct {
 type Version struct {
 	Creator   Creator `json:"creator"`
 	NeedsInfo `json:"needs"`
+	// Types and defaults of the need fields, older sphinx-needs versions do not write it
+	Schema *NeedsSchema `json:"needs_schema,omitempty"`
 }
 
 type NeedsInfo map[string]Need
@@ -26,7 +28,7 @@ type Need struct {
 	Docname          string      `json:"docname,omitempty"`
 	ID               string      `json:"id,omitempty"`
 	Lineno           int         `json:"lineno,omitempty"`
-	ReqType          string      `json:"req_type,omitempty"`
+	ReqType          string      `json:"reqtype,omitempty"`
 	Safety           string      `json:"safety,omitempty"`
 	SectionName      string      `json:"section_name,omitempty"`
 	Security         string      `json:"security,omitempty"`
@@ -34,7 +36,7 @@ type Need struct {
 	Title            string      `json:"title,omitempty"`
 	Type             string      `json:"type,omitempty"`
 	TypeName         string      `json:"type_name,omitempty"`
-	DocType          string      `json:"doc_type,omitempty"`
+	DocType          string      `json:"doctype,omitempty"`
 	IsExternal       bool        `json:"is_external,omitempty"`
 	Tags             StringSlice `json:"tags,omitempty"`
 	Approvers        StringSlice `json:"approvers,omitempty"`
@@ -65,25 +67,55 @@ type Need struct {
 	Uses        StringSlice `json:"uses,omitempty"`
 	Includes    StringSlice `json:"includes,omitempty"`
 	IncludedBy  StringSlice `json:"included_by,omitempty"`
+
+	// Set if the need does not exist in the version of the needs.json we use,
+	// only in this other one. See SelectNeeds.
+	OnlyInVersion string `json:"-"`
+
+	// The needs.json the need was loaded from, see Source
+	source *NeedsSource
+
+	// Every field of the needs.json, also the ones above. See Field.
+	fields map[string]any
+	schema *NeedsSchema
 }
 
 type NeedsJsonInfo struct {
-	CurrentVersion string             `json:"currentVersion"`
+	CurrentVersion string             `json:"current_version"`
 	Project        string             `json:"project"`
 	Versions       map[string]Version `json:"versions"`
 }
 
-func (n Need) GenerateHoverInfo() string {
+// GenerateHoverInfo also shows the extra fields, any field of the needs.json can be used.
+func (n Need) GenerateHoverInfo(extraFields []string) string {
 	// Type,Status,Implemented
-	return fmt.Sprintf("Type: %s\nStatus: %s\nImplemented: %s\n\n %s", n.Type, n.Status, n.Implemented, n.Content)
+	info := n.versionNote() + n.sourceNote() + fmt.Sprintf("Type: %s\nStatus: %s\nImplemented: %s\n", n.Type, n.Status, n.Implemented)
+	for _, field := range extraFields {
+		if value := n.StringField(field); value != "" {
+			info += fmt.Sprintf("%s: %s\n", field, value)
+		}
+	}
+	return info + "\n " + n.Content
 }
 
 func (n Need) GenerateCompletionInfo() lsp.CompletionItem {
-	return lsp.CompletionItem{
+	item := lsp.CompletionItem{
 		Label:            n.ID,
-		Detail:           fmt.Sprintf("Type: %s\nStatus: %s\nImplemented: %s\n\n %s", n.Type, n.Status, n.Implemented, n.Content),
+		Detail:           n.versionNote() + n.sourceNote() + fmt.Sprintf("Type: %s\nStatus: %s\nImplemented: %s\n\n %s", n.Type, n.Status, n.Implemented, n.Content),
 		Documentation:    n.Content,
 		InsertText:       n.ID,
 		InsertTextFormat: 1,
 	}
+	if n.OnlyInVersion != "" {
+		item.Tags = []lsp.CompletionItemTag{lsp.CompletionItemTagDeprecated}
+	}
+	return item
+}
+
+// versionNote warns about needs that are gone from the version we use.
+func (n Need) versionNote() string {
+	if n.OnlyInVersion == "" {
+		return ""
+	}
+	return fmt.Sprintf("Only exists in version %s of %s\n\n", n.OnlyInVersion, n.sourceName())
 }
@@ -2,30 +2,123 @@ package internal
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	//"github.com/yassinebenaid/godump"
 )
 
-func ParseNeedsJson(needsPath string, logger *log.Logger) NeedsJsonInfo {
+// ParseNeedsJson reads the needs.json at needsPath.
+func ParseNeedsJson(needsPath string) (NeedsJsonInfo, error) {
 	needsJsonFile, err := os.ReadFile(needsPath)
 	if err != nil {
-		logger.Printf("could not open needsJsonFile. Path: %s Error: %s", needsPath, err.Error())
+		return NeedsJsonInfo{}, fmt.Errorf("could not open needs.json: %w", err)
 	}
+	return parseNeedsJsonData(needsJsonFile)
+}
 
+// parseNeedsJsonData parses the content of a needs.json, wherever it came from.
+func parseNeedsJsonData(data []byte) (NeedsJsonInfo, error) {
 	var needsJson NeedsJsonInfo
-	if err := json.Unmarshal(needsJsonFile, &needsJson); err != nil {
-		logger.Printf("could not parse stuff: %s", err.Error())
+	if err := json.Unmarshal(data, &needsJson); err != nil {
+		return NeedsJsonInfo{}, fmt.Errorf("could not parse needs.json: %w", err)
 	}
 	// DEBUGGING PRINTS
 	//t := needsJson.Versions["0.1"].Needs["feat_req__example__some_title"]
 	//var d godump.Dumper
 	//logger.Println(d.Sprintln(t))
 	//logger.Printf("This is one needsJson parsed: %v\n", needsJson.Versions["0.1"].Needs["feat_req__example__some_title"])
-	return needsJson
+	return needsJson, nil
 }
 
-// HACK: Version is assumed 0.1 for now. This might not be ideal
+// GetNeedsList returns the needs of the current version of the needs.json.
 func GetNeedsList(needsJSON NeedsJsonInfo) NeedsInfo {
-	return needsJSON.Versions["0.1"].NeedsInfo
+	return needsJSON.Versions[needsJSON.selectVersion("")].NeedsInfo
+}
+
+// selectVersion returns override if it is set, otherwise the current_version of the file.
+// Files without a current_version but only one version use that one.
+func (nj NeedsJsonInfo) selectVersion(override string) string {
+	if override != "" {
+		return override
+	}
+	if nj.CurrentVersion == "" && len(nj.Versions) == 1 {
+		for version := range nj.Versions {
+			return version
+		}
+	}
+	return nj.CurrentVersion
+}
+
+// VersionNames lists the versions in the file, the newest first.
+func (nj NeedsJsonInfo) VersionNames() []string {
+	names := slices.Collect(maps.Keys(nj.Versions))
+	slices.SortFunc(names, func(a, b string) int { return compareVersions(b, a) })
+	return names
+}
+
+// SelectNeeds returns the needs of version (see selectVersion) together with the needs that only exist
+// in one of the others, "*" stands for every version in the file.
+// Those are marked with the newest of the others they exist in, see Need.OnlyInVersion.
+// A file without version is an error, it is the wrong file or one that is still being written.
+func SelectNeeds(needsJSON NeedsJsonInfo, version string, others []string, logger *slog.Logger) (NeedsInfo, error) {
+	version = needsJSON.selectVersion(version)
+	current, ok := needsJSON.Versions[version]
+	if !ok {
+		return nil, fmt.Errorf("needs.json does not contain version %q, it has %v", version, needsJSON.VersionNames())
+	}
+	if len(others) == 0 {
+		return current.NeedsInfo, nil
+	}
+	if slices.Contains(others, "*") {
+		others = needsJSON.VersionNames()
+	} else {
+		others = slices.Clone(others)
+		slices.SortFunc(others, func(a, b string) int { return compareVersions(b, a) })
+	}
+
+	needs := make(NeedsInfo, len(current.NeedsInfo))
+	maps.Copy(needs, current.NeedsInfo)
+	for _, other := range others {
+		if other == version {
+			continue
+		}
+		older, ok := needsJSON.Versions[other]
+		if !ok {
+			logger.Warn("needs.json does not contain the version", "version", other, "versions", needsJSON.VersionNames())
+			continue
+		}
+		for id, need := range older.NeedsInfo {
+			if _, ok := needs[id]; ok {
+				// Exists in the version we use or a newer one already
+				continue
+			}
+			need.OnlyInVersion = other
+			needs[id] = need
+		}
+	}
+	return needs, nil
+}
+
+// compareVersions compares versions like "1.10.2" part by part, numbers by their value.
+func compareVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range min(len(aParts), len(bParts)) {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		var c int
+		if aErr == nil && bErr == nil {
+			c = aNum - bNum
+		} else {
+			c = strings.Compare(aParts[i], bParts[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return len(aParts) - len(bParts)
 }
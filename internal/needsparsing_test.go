@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+const multiVersionNeedsJson = `{
+  "current_version": "1.10",
+  "project": "test",
+  "versions": {
+    "1.10": {"needs": {"REQ_1": {"id": "REQ_1", "title": "new"}}},
+    "1.9": {"needs": {"REQ_1": {"id": "REQ_1", "title": "old"}, "REQ_2": {"id": "REQ_2", "title": "1.9"}}},
+    "1.2": {"needs": {"REQ_2": {"id": "REQ_2", "title": "1.2"}, "REQ_3": {"id": "REQ_3"}}}
+  }
+}`
+
+func TestSelectNeeds(t *testing.T) {
+	var needsJson NeedsJsonInfo
+	if err := json.Unmarshal([]byte(multiVersionNeedsJson), &needsJson); err != nil {
+		t.Fatal(err)
+	}
+	if needsJson.CurrentVersion != "1.10" {
+		t.Fatalf("CurrentVersion = %q, want 1.10", needsJson.CurrentVersion)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	tests := []struct {
+		name    string
+		version string
+		others  []string
+		// ID => title and the version it only exists in
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "current version",
+			want: map[string]string{"REQ_1": "new"},
+		},
+		{
+			name:    "override",
+			version: "1.9",
+			want:    map[string]string{"REQ_1": "old", "REQ_2": "1.9"},
+		},
+		{
+			name:    "unknown version",
+			version: "2.0",
+			wantErr: true,
+		},
+		{
+			name:   "newest older version wins",
+			others: []string{"1.2", "1.9", "3.0"},
+			want:   map[string]string{"REQ_1": "new", "REQ_2": "1.9 only in 1.9", "REQ_3": " only in 1.2"},
+		},
+		{
+			name:    "all versions",
+			version: "1.9",
+			others:  []string{"*"},
+			want:    map[string]string{"REQ_1": "old", "REQ_2": "1.9", "REQ_3": " only in 1.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			needs, err := SelectNeeds(needsJson, tt.version, tt.others, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectNeeds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(needs) != len(tt.want) {
+				t.Errorf("SelectNeeds() returned %d needs, want %d", len(needs), len(tt.want))
+			}
+			for id, want := range tt.want {
+				need := needs[id]
+				got := need.Title
+				if need.OnlyInVersion != "" {
+					got += " only in " + need.OnlyInVersion
+				}
+				if got != want {
+					t.Errorf("SelectNeeds()[%s] = %q, want %q", id, got, want)
+				}
+			}
+		})
+	}
+
+	if got := needsJson.VersionNames(); strings.Join(got, ",") != "1.10,1.9,1.2" {
+		t.Errorf("VersionNames() = %v, want newest first", got)
+	}
+	single := NeedsJsonInfo{Versions: map[string]Version{"0.1": {NeedsInfo: NeedsInfo{"REQ_1": {}}}}}
+	if got := GetNeedsList(single); len(got) != 1 {
+		t.Errorf("GetNeedsList() without current_version = %v, want the only version", got)
+	}
+}
+
+func TestOnlyInOlderVersion(t *testing.T) {
+	state := createTestState()
+	state.Needs.Update(NeedsInfo{
+		"REQ_001": {ID: "REQ_001"},
+		"REQ_OLD": {ID: "REQ_OLD", OnlyInVersion: "0.9"},
+	})
+	uri := "file:///test.py"
+	diagnostics := state.OpenDocument(uri, "# req-Id: REQ_001, REQ_OLD")
+	if len(diagnostics) != 1 || diagnostics[0].Severity != 2 || !strings.Contains(diagnostics[0].Message, "only exists in version 0.9") {
+		t.Errorf("OpenDocument() diagnostics = %+v, want a warning about REQ_OLD", diagnostics)
+	}
+
+	need := state.Needs.Needs()["REQ_OLD"]
+	if !strings.HasPrefix(need.GenerateHoverInfo(nil), "Only exists in version 0.9") {
+		t.Errorf("GenerateHoverInfo() = %q, want a note about the version", need.GenerateHoverInfo(nil))
+	}
+	if item := need.GenerateCompletionInfo(); len(item.Tags) != 1 {
+		t.Errorf("GenerateCompletionInfo() tags = %v, want it marked deprecated", item.Tags)
+	}
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// NeedsSource is one needs.json the needs are loaded from, e.g. our own and the one of the upstream platform.
+type NeedsSource struct {
+	Path string `json:"path"`
+	// Fetch the needs.json from here instead of Path, e.g. from the published docs. See NeedsFetcher.
+	URL string `json:"url"`
+	// Shown in hover and diagnostics, the path if empty
+	Name string `json:"name"`
+	// Put in front of every ID of the file. Projects strip their id_prefix before building the needs.json,
+	// so e.g. "SCORE_" makes the upstream needs known as we reference them.
+	IDPrefix string `json:"idPrefix"`
+	// Needs of another project, their definition can only be opened if DocsRoot is set
+	External bool `json:"external"`
+	// Folder the docnames of the needs are relative to, DocumentRootPath of the config if empty
+	DocsRoot string `json:"docsRoot"`
+	// Like NeedsVersion and OtherNeedsVersions of the config, but for this file
+	Version       string   `json:"version"`
+	OtherVersions []string `json:"otherVersions"`
+
+	// The first source, its needs are the ones of our own project
+	primary bool
+}
+
+// DisplayName is how hover and diagnostics call the source.
+func (ns *NeedsSource) DisplayName() string {
+	if ns.Name != "" {
+		return ns.Name
+	}
+	return ns.location()
+}
+
+func (ns *NeedsSource) location() string {
+	if ns.URL != "" {
+		return ns.URL
+	}
+	return ns.Path
+}
+
+// parse reads the needs.json of the source. One from a URL comes from the cache,
+// it is only fetched if fetchMissing is set and it was never fetched before.
+func (ns *NeedsSource) parse(fetcher NeedsFetcher, fetchMissing bool, logger *slog.Logger) (NeedsJsonInfo, error) {
+	if ns.URL == "" {
+		return ParseNeedsJson(ns.Path)
+	}
+	data, err := fetcher.Cached(ns.URL)
+	if err != nil && fetchMissing {
+		data, _, err = fetcher.Fetch(ns.URL, logger)
+	}
+	if err != nil {
+		return NeedsJsonInfo{}, err
+	}
+	return parseNeedsJsonData(data)
+}
+
+// Sources lists every needs.json to load: NeedsJsonPath first, then NeedsSources.
+// The first one is our own project, whatever comes after is shown with its name.
+func (c ServerConfig) Sources() []*NeedsSource {
+	var sources []*NeedsSource
+	if c.NeedsJsonPath != "" {
+		primary := &NeedsSource{
+			Path:          c.NeedsJsonPath,
+			Version:       c.NeedsVersion,
+			OtherVersions: c.OtherNeedsVersions,
+		}
+		if IsURL(primary.Path) {
+			primary.URL, primary.Path = primary.Path, ""
+		}
+		sources = append(sources, primary)
+	}
+	for _, source := range c.NeedsSources {
+		sources = append(sources, &source)
+	}
+	for _, source := range sources {
+		if source.DocsRoot == "" && !source.External {
+			source.DocsRoot = c.DocumentRootPath
+		}
+	}
+	if len(sources) > 0 {
+		sources[0].primary = true
+	}
+	return sources
+}
+
+// IsURL reports if a needs.json location has to be fetched, see NeedsFetcher.
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// sourceForID returns the source whose prefix id starts with, the longest one if several match.
+func (c ServerConfig) sourceForID(id string) *NeedsSource {
+	var found *NeedsSource
+	for _, source := range c.Sources() {
+		if source.IDPrefix == "" || !strings.HasPrefix(id, source.IDPrefix) {
+			continue
+		}
+		if found == nil || len(source.IDPrefix) > len(found.IDPrefix) {
+			found = source
+		}
+	}
+	return found
+}
+
+// loadSources parses every source of the config, the needs of each with its prefix applied.
+// A source that can not be parsed keeps its needs from previous (by location), so one broken
+// or missing file does not take the needs of the others with it. Its error is returned in errs.
+// Sources from URLs are read from the cache, FetchRemoteSources keeps it up to date.
+// Only if fetchMissing is set those that are not cached yet are fetched.
+func loadSources(config ServerConfig, previous map[string]NeedsInfo, fetchMissing bool, logger *slog.Logger) (loaded map[string]NeedsInfo, errs []error) {
+	loaded = make(map[string]NeedsInfo)
+	fetcher := NewNeedsFetcher(config)
+	for _, source := range config.Sources() {
+		needs, err := source.load(fetcher, fetchMissing, logger)
+		if err != nil && config.RstNeeds && source.primary && errors.Is(err, fs.ErrNotExist) {
+			// Not built yet, the rst files have our needs
+			logger.Info("No needs.json, only using the needs of the rst files", "path", source.Path)
+			needs, err = NeedsInfo{}, nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.location(), err))
+			if needs, ok := previous[source.location()]; ok {
+				loaded[source.location()] = needs
+			}
+			continue
+		}
+		loaded[source.location()] = needs
+	}
+	return loaded, errs
+}
+
+// load parses the needs of the source, with its prefix in front of their IDs.
+func (ns *NeedsSource) load(fetcher NeedsFetcher, fetchMissing bool, logger *slog.Logger) (NeedsInfo, error) {
+	needsJson, err := ns.parse(fetcher, fetchMissing, logger)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := SelectNeeds(needsJson, ns.Version, ns.OtherVersions, logger)
+	if err != nil {
+		return nil, err
+	}
+	needs := make(NeedsInfo, len(selected))
+	for id, need := range selected {
+		needs[ns.IDPrefix+id] = need.withSource(ns)
+	}
+	return needs, nil
+}
+
+// mergeSources puts the needs of all sources together, the first source wins if an ID is in several.
+// The needs of the rst files go over them if the config asks for it.
+func mergeSources(config ServerConfig, loaded map[string]NeedsInfo, logger *slog.Logger) NeedsInfo {
+	needs := make(NeedsInfo)
+	sources := config.Sources()
+	for _, source := range sources {
+		sourceNeeds := loaded[source.location()]
+		// Sorted, so the same need is kept every time if there are duplicates
+		for _, id := range slices.Sorted(maps.Keys(sourceNeeds)) {
+			if existing, ok := needs[id]; ok {
+				logger.Warn("Need exists in more than one source, keeping the first", "need", id, "kept", existing.source.DisplayName(), "ignored", source.DisplayName())
+				continue
+			}
+			needs[id] = sourceNeeds[id]
+		}
+	}
+	if config.RstNeeds {
+		var primary *NeedsSource
+		var schema *NeedsSchema
+		if len(sources) > 0 {
+			primary = sources[0]
+			schema = schemaOf(loaded[primary.location()])
+		}
+		rstNeeds, err := ParseRstNeeds(config.DocumentRootPath, schema, logger)
+		if err != nil {
+			// Whatever was found is still better than the needs of the last docs build
+			logger.Warn("Could not read all rst files", "err", err)
+		}
+		mergeRstNeeds(needs, rstNeeds, primary)
+	}
+	return needs
+}
+
+// schemaOf returns the needs_schema of the needs of one source, the rst needs are typed with it.
+func schemaOf(needs NeedsInfo) *NeedsSchema {
+	for _, need := range needs {
+		// Needs of older versions can have an older schema
+		if need.OnlyInVersion == "" && need.schema != nil {
+			return need.schema
+		}
+	}
+	return nil
+}
+
+// withSource returns the need as it is known when loaded from source, with the prefix in front of its ID
+// and of the IDs it links to. Links between the needs of one file do not carry the prefix either.
+func (n Need) withSource(source *NeedsSource) Need {
+	n.source = source
+	if source.IDPrefix == "" {
+		return n
+	}
+	fields := make(map[string]any, len(n.fields))
+	maps.Copy(fields, n.fields)
+	if n.ID != "" {
+		n.ID = source.IDPrefix + n.ID
+		fields["id"] = n.ID
+	}
+	links, backlinks := linkFields(n.schema)
+	for _, name := range slices.Concat(links, backlinks) {
+		ids := n.StringsField(name)
+		if ids == nil {
+			continue
+		}
+		prefixed := make([]string, len(ids))
+		for i, id := range ids {
+			prefixed[i] = source.IDPrefix + id
+		}
+		fields[name] = prefixed
+	}
+	n.fields = fields
+	return n
+}
+
+// Source returns the source the need was loaded from, nil if it was not loaded from a needs.json.
+func (n Need) Source() *NeedsSource {
+	return n.source
+}
+
+// sourceName is how messages about the need call its needs.json.
+func (n Need) sourceName() string {
+	if n.source == nil || n.source.primary {
+		return "the needs.json"
+	}
+	return n.source.DisplayName()
+}
+
+// sourceNote says where needs of other projects come from.
+func (n Need) sourceNote() string {
+	if n.source == nil || n.source.primary {
+		return ""
+	}
+	if n.source.External {
+		return fmt.Sprintf("Source: %s (external)\n", n.source.DisplayName())
+	}
+	return fmt.Sprintf("Source: %s\n", n.source.DisplayName())
+}
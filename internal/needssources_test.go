@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sclls/lsp"
+)
+
+func TestNeedsSources(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dir := t.TempDir()
+	config := ServerConfig{
+		NeedsJsonPath:    filepath.Join(dir, "needs.json"),
+		DocumentRootPath: "docs",
+		TemplateStrings:  []string{"# req-Id: "},
+		NeedsSources: []NeedsSource{
+			{Path: filepath.Join(dir, "score.json"), Name: "score", IDPrefix: "SCORE_", External: true},
+			{Path: filepath.Join(dir, "other.json"), Name: "other", DocsRoot: "other/docs"},
+		},
+	}
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {
+		"tool_req__a": {"id": "tool_req__a", "satisfies": ["SCORE_feat_req__x"]}
+	}}}}`)
+	// Upstream needs link to each other without the prefix
+	writeFile(t, config.NeedsSources[0].Path, `{"current_version": "1", "versions": {"1": {"needs": {
+		"feat_req__x": {"id": "feat_req__x", "docname": "x", "satisfies": ["stkh_req__y"]},
+		"stkh_req__y": {"id": "stkh_req__y"}
+	}}}}`)
+	writeFile(t, config.NeedsSources[1].Path, `{"current_version": "1", "versions": {"1": {"needs": {
+		"tool_req__a": {"id": "tool_req__a", "title": "duplicate"},
+		"doc_req__b": {"id": "doc_req__b", "docname": "b", "lineno": 3}
+	}}}}`)
+
+	index := LoadNeedsIndex(config, logger)
+	needs := index.Needs()
+	for _, id := range []string{"tool_req__a", "SCORE_feat_req__x", "SCORE_stkh_req__y", "doc_req__b"} {
+		if _, ok := needs[id]; !ok {
+			t.Errorf("LoadNeedsIndex() is missing %s, got %d needs", id, len(needs))
+		}
+	}
+	if len(needs) != 4 || needs["tool_req__a"].Title == "duplicate" {
+		t.Errorf("LoadNeedsIndex() = %v, want the first source to win for duplicates", needs)
+	}
+	if need := needs["SCORE_feat_req__x"]; need.ID != "SCORE_feat_req__x" || need.Source().Name != "score" {
+		t.Errorf("Need of the score source = %+v", need)
+	}
+	graph := index.Graph()
+	if got := strings.Join(ids(graph.Ancestors("tool_req__a")), ","); got != "SCORE_feat_req__x,SCORE_stkh_req__y" {
+		t.Errorf("Ancestors() = %s, want the links inside the prefixed source to resolve", got)
+	}
+	if dangling := graph.Dangling(); len(dangling) != 0 {
+		t.Errorf("Dangling() = %v, want none", dangling)
+	}
+
+	if hover := needs["SCORE_feat_req__x"].GenerateHoverInfo(nil); !strings.HasPrefix(hover, "Source: score (external)\n") {
+		t.Errorf("GenerateHoverInfo() = %q, want the source", hover)
+	}
+	if hover := needs["tool_req__a"].GenerateHoverInfo(nil); strings.Contains(hover, "Source") {
+		t.Errorf("GenerateHoverInfo() = %q, want no source for our own needs", hover)
+	}
+
+	state := NewSession(config, index, logger)
+	uri := "file:///test.py"
+	diagnostics := state.OpenDocument(uri, "# req-Id: SCORE_feat_req__x, SCORE_feat_req__z, doc_req__b")
+	if len(diagnostics) != 1 || !strings.Contains(diagnostics[0].Message, "'SCORE_feat_req__z' not found in score.") {
+		t.Errorf("OpenDocument() diagnostics = %+v, want SCORE_feat_req__z to be missing in score", diagnostics)
+	}
+
+	// External without a docs root has nowhere to go
+	if response := state.GoToDefinition(lsp.NewIntID(1), uri, lsp.Position{Line: 0, Character: 12}); len(response.Result) != 0 {
+		t.Errorf("GoToDefinition() = %v, want nothing for an external need", response.Result)
+	}
+	response := state.GoToDefinition(lsp.NewIntID(2), uri, lsp.Position{Line: 0, Character: 52})
+	if len(response.Result) != 1 || !strings.HasSuffix(response.Result[0].URI, "other/docs/b.rst") || response.Result[0].Range.Start.Line != 2 {
+		t.Errorf("GoToDefinition() = %+v, want the docs root of the other source", response.Result)
+	}
+
+	// A broken source keeps its needs, the others are reloaded anyway
+	writeFile(t, config.NeedsSources[1].Path, `{"versions": `)
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {"needs": {
+		"tool_req__a": {"id": "tool_req__a", "satisfies": ["SCORE_feat_req__x"]},
+		"tool_req__b": {"id": "tool_req__b"}
+	}}}}`)
+	err := index.Reload(config, logger)
+	needs = index.Needs()
+	if err == nil || !strings.Contains(err.Error(), "other.json") || len(needs) != 5 {
+		t.Errorf("Reload() error = %v with %d needs, want an error about other.json and 5 needs", err, len(needs))
+	}
+	if _, ok := needs["doc_req__b"]; !ok {
+		t.Errorf("Reload() dropped the needs of the broken source")
+	}
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// .. <type>:: <title>
+	directivePattern = regexp.MustCompile(`^([ \t]*)\.\.[ \t]+([A-Za-z][\w-]*)::(?:[ \t]+(.*?))?[ \t]*$`)
+	// :<option>: <value>
+	optionPattern = regexp.MustCompile(`^([ \t]+):([\w-]+):(?:[ \t]+(.*?))?[ \t]*$`)
+)
+
+// ParseRstNeeds scans every rst file below docsRoot for need directives, so needs are known
+// without building the docs. Fields are typed by schema like those of a needs.json, it can be nil.
+// Files that can not be read are returned as errors, the needs of all others anyway.
+func ParseRstNeeds(docsRoot string, schema *NeedsSchema, logger *slog.Logger) (NeedsInfo, error) {
+	needs := make(NeedsInfo)
+	var errs []error
+	err := filepath.WalkDir(docsRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if entry.IsDir() {
+			// The output of sphinx-build and hidden folders have copies of the sources
+			if path != docsRoot && (entry.Name() == "_build" || strings.HasPrefix(entry.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".rst" {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		rel, err := filepath.Rel(docsRoot, path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		docname := filepath.ToSlash(strings.TrimSuffix(rel, ".rst"))
+		for _, fields := range parseRstDirectives(docname, string(content)) {
+			need := needFromFields(fields, schema)
+			if existing, ok := needs[need.ID]; ok {
+				logger.Warn("Need is defined twice, keeping the first", "need", need.ID, "kept", existing.Docname, "ignored", docname)
+				continue
+			}
+			needs[need.ID] = need
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return needs, errors.Join(errs...)
+}
+
+// parseRstDirectives returns the fields of every directive with an :id: in content,
+// those without one are not needs or get their ID generated by sphinx-needs.
+func parseRstDirectives(docname, content string) []map[string]any {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	var directives []map[string]any
+	// Nested needs are part of the content of their parent and found on their own as well
+	for i, line := range lines {
+		m := directivePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		indent := len(m[1])
+		fields := map[string]any{
+			"type":    m[2],
+			"title":   m[3],
+			"docname": docname,
+			"lineno":  i + 1,
+		}
+		j := parseRstOptions(lines, i+1, indent, fields)
+		fields["content"] = parseRstContent(lines, j, indent)
+		if id, _ := fields["id"].(string); id != "" {
+			directives = append(directives, fields)
+		}
+	}
+	return directives
+}
+
+// parseRstOptions reads the options of a directive starting at line start into fields,
+// it returns the line after them. Values can go on over more indented lines.
+func parseRstOptions(lines []string, start, indent int, fields map[string]any) int {
+	optionIndent := -1
+	lastOption := ""
+	j := start
+	for ; j < len(lines); j++ {
+		line := lines[j]
+		trimmed := strings.TrimSpace(line)
+		lineIndent := indentation(line)
+		if trimmed == "" || lineIndent <= indent {
+			break
+		}
+		if m := optionPattern.FindStringSubmatch(line); m != nil && (optionIndent == -1 || lineIndent == optionIndent) {
+			optionIndent = lineIndent
+			lastOption = m[2]
+			fields[lastOption] = m[3]
+			continue
+		}
+		switch {
+		case lastOption != "" && lineIndent > optionIndent:
+			fields[lastOption] = strings.TrimSpace(fields[lastOption].(string) + " " + trimmed)
+		case lastOption == "":
+			// The title goes on until the options start
+			fields["title"] = strings.TrimSpace(fields["title"].(string) + " " + trimmed)
+		default:
+			return j
+		}
+	}
+	return j
+}
+
+// parseRstContent returns the indented block of a directive starting at line start, without its indentation.
+func parseRstContent(lines []string, start, indent int) string {
+	var block []string
+	for j := start; j < len(lines); j++ {
+		line := lines[j]
+		if strings.TrimSpace(line) == "" {
+			block = append(block, "")
+			continue
+		}
+		if indentation(line) <= indent {
+			break
+		}
+		block = append(block, line)
+	}
+	for len(block) > 0 && block[0] == "" {
+		block = block[1:]
+	}
+	for len(block) > 0 && block[len(block)-1] == "" {
+		block = block[:len(block)-1]
+	}
+	common := -1
+	for _, line := range block {
+		if line != "" && (common == -1 || indentation(line) < common) {
+			common = indentation(line)
+		}
+	}
+	for i, line := range block {
+		if line != "" {
+			block[i] = line[common:]
+		}
+	}
+	return strings.Join(block, "\n")
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// needFromFields builds a need like one parsed from a needs.json with these fields.
+func needFromFields(fields map[string]any, schema *NeedsSchema) Need {
+	var need Need
+	need.fields = fields
+	if schema != nil {
+		need.applySchema(schema)
+	}
+	// Text and what JSON decodes to always marshal
+	data, _ := json.Marshal(need.fields)
+	// Without a schema options are text, fields of Need with another type are then only kept in fields.
+	// Unmarshal fills in all others anyway.
+	type plainNeed Need
+	var plain plainNeed
+	_ = json.Unmarshal(data, &plain)
+	plain.fields = need.fields
+	plain.schema = need.schema
+	return Need(plain)
+}
+
+// mergeRstNeeds puts the needs found in the rst files over those of the needs.json.
+// Their links come from the rst files only, so removed links do not stay around.
+// Needs that are new since the last docs build are put into source, the one of our own docs.
+func mergeRstNeeds(needs, rstNeeds NeedsInfo, source *NeedsSource) {
+	for id, rstNeed := range rstNeeds {
+		base, ok := needs[id]
+		if !ok {
+			rstNeed.source = source
+			needs[id] = rstNeed
+			continue
+		}
+		fields := make(map[string]any, len(base.fields)+len(rstNeed.fields))
+		links, backlinks := linkFields(base.schema)
+		for name, value := range base.fields {
+			if !slices.Contains(links, name) && !slices.Contains(backlinks, name) {
+				fields[name] = value
+			}
+		}
+		maps.Copy(fields, rstNeed.fields)
+		merged := needFromFields(fields, base.schema)
+		merged.source = base.source
+		needs[id] = merged
+	}
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRstDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []map[string]any
+	}{
+		{
+			name: "need with options and content",
+			content: `Title
+=====
+
+.. feat_req:: Some title
+   :id: feat_req__x
+   :status: valid
+   :satisfies: stkh_req__a,
+      stkh_req__b
+
+   The content.
+
+      Indented more.
+
+After the need`,
+			want: []map[string]any{{
+				"type": "feat_req", "title": "Some title", "docname": "doc", "lineno": 4,
+				"id": "feat_req__x", "status": "valid", "satisfies": "stkh_req__a, stkh_req__b",
+				"content": "The content.\n\n   Indented more.",
+			}},
+		},
+		{
+			name:    "title over two lines and a flag",
+			content: ".. req:: A long\n   title\n   :id: REQ_1\n   :collapse:",
+			want: []map[string]any{{
+				"type": "req", "title": "A long title", "docname": "doc", "lineno": 1,
+				"id": "REQ_1", "collapse": "", "content": "",
+			}},
+		},
+		{
+			name:    "nested needs",
+			content: "  .. req:: Parent\n     :id: REQ_1\n\n     .. spec:: Child\n        :id: SPEC_1\n\n        Child content",
+			want: []map[string]any{
+				{
+					"type": "req", "title": "Parent", "docname": "doc", "lineno": 1, "id": "REQ_1",
+					"content": ".. spec:: Child\n   :id: SPEC_1\n\n   Child content",
+				},
+				{"type": "spec", "title": "Child", "docname": "doc", "lineno": 4, "id": "SPEC_1", "content": "Child content"},
+			},
+		},
+		{
+			name:    "directives without id",
+			content: ".. code-block:: python\n\n   print(1)\n\n.. note::\n   :class: x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRstDirectives("doc", tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRstDirectives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRstNeeds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	writeRst := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(docs, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path, content)
+	}
+	writeRst("features/index.rst", ".. feat_req:: New title\n   :id: feat_req__x\n   :lineno_test: 3\n   :tags: a, b\n\n.. feat_req:: Not built yet\n   :id: feat_req__new\n   :satisfies: stkh_req__a\n")
+	writeRst("_build/html/_sources/features/index.rst", ".. feat_req:: Copy\n   :id: feat_req__copy\n")
+
+	config := ServerConfig{DocumentRootPath: docs, RstNeeds: true, NeedsJsonPath: filepath.Join(dir, "needs.json")}
+	// Only the rst files until the docs are built
+	if needs := LoadNeedsIndex(config, logger).Needs(); len(needs) != 2 || needs["feat_req__new"].Docname != "features/index" || needs["feat_req__new"].Lineno != 6 {
+		t.Errorf("LoadNeedsIndex() without needs.json = %v, want the 2 needs of the rst file", needs)
+	}
+
+	writeFile(t, config.NeedsJsonPath, `{"current_version": "1", "versions": {"1": {
+		"needs_schema": {"properties": {
+			"lineno_test": {"type": "integer", "field_type": "extra"},
+			"satisfies": {"type": "array", "items": {"type": "string"}, "field_type": "links"}
+		}},
+		"needs": {
+			"feat_req__x": {"id": "feat_req__x", "title": "Old title", "type_name": "Feature Requirement", "satisfies": ["stkh_req__a"]},
+			"stkh_req__a": {"id": "stkh_req__a"}
+		}
+	}}}`)
+	index := LoadNeedsIndex(config, logger)
+	needs := index.Needs()
+	if len(needs) != 3 {
+		t.Fatalf("LoadNeedsIndex() = %v, want the needs of both", needs)
+	}
+	x := needs["feat_req__x"]
+	if x.Title != "New title" || x.TypeName != "Feature Requirement" || x.Lineno != 1 || len(x.Tags) != 2 {
+		t.Errorf("Merged need = %+v, want the rst over the needs.json", x)
+	}
+	if value, _ := x.Field("lineno_test"); value != 3 {
+		t.Errorf("Field(lineno_test) = %#v, want it typed by the schema", value)
+	}
+	if got := ids(index.Graph().Children("stkh_req__a")); !reflect.DeepEqual(got, []string{"feat_req__new"}) {
+		t.Errorf("Children() = %v, want the links of the rst files", got)
+	}
+	if x.Source() == nil || needs["feat_req__new"].Source() == nil || needs["feat_req__new"].Source().Path != x.Source().Path {
+		t.Errorf("Need new in the rst files has source %v, want the one of the needs.json", needs["feat_req__new"].Source())
+	}
+}
@@ -1,8 +1,29 @@
 package internal
 
+import "time"
+
 type ServerConfig struct {
-	NeedsJsonPath    string   `json:"needsJsonPath"`
+	NeedsJsonPath string `json:"needsJsonPath"`
+	// Version of the needs.json to use, its current_version if empty
+	NeedsVersion string `json:"needsVersion"`
+	// Versions loaded next to NeedsVersion for needs that do not exist there anymore, "*" for all
+	OtherNeedsVersions []string `json:"otherNeedsVersions"`
+	// More needs.json files next to NeedsJsonPath, e.g. of the upstream platform. See Sources.
+	NeedsSources []NeedsSource `json:"needsSources"`
+	// Where needs.json files from URLs are kept for offline use, sclls in the user cache folder if empty
+	NeedsCacheDir string `json:"needsCacheDir"`
+	// How often needs.json files from URLs are checked for changes, 0 turns it off
+	NeedsRefreshInterval time.Duration `json:"needsRefreshInterval"`
+	// Also find the needs in the rst files below DocumentRootPath, they are put over those of the needs.json
+	RstNeeds         bool     `json:"rstNeeds"`
 	DocumentRootPath string   `json:"documentRootPath"`
 	Enabled          bool     `json:"enabled"`
 	TemplateStrings  []string `json:"templateStrings"`
+	DisabledMethods  []string `json:"disabledMethods"`
+	// Fields of the needs (e.g. from needs_extra_options) that hover shows as well
+	HoverFields []string `json:"hoverFields"`
+	// Regular expression matching one character of a need ID, DefaultIDCharacters if empty
+	IDCharacters string `json:"idCharacters"`
+	// How long to wait for more changes before diagnostics are published
+	DiagnosticsDelay time.Duration `json:"diagnosticsDelay"`
 }
@@ -1,91 +1,199 @@
 package internal
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sclls/lsp"
+	"sort"
 	"strings"
+	"sync"
 )
 
+// State is shared between concurrently running requests.
+// Notifications that change documents take the write lock, requests only read.
 type State struct {
+	mu sync.RWMutex
 	// Document URI => Information
 	Documents map[string]*DocumentInfo
-	NeedsList NeedsInfo
+	// Shared with the other sessions of the server
+	Needs *NeedsIndex
 	ServerConfig
-	Logger *log.Logger
+	Logger *slog.Logger
+
+	status   ServerStatus
+	exitCode int
+	// What the characters of positions count, negotiated on initialize
+	encoding lsp.PositionEncodingKind
+	// Built from the config, see needDetector
+	detector *NeedDetector
 }
 
-func NewState(srvConfig ServerConfig, logger *log.Logger) State {
-	needsJson := ParseNeedsJson(srvConfig.NeedsJsonPath, logger)
-	needsList := GetNeedsList(needsJson)
+func NewState(srvConfig ServerConfig, logger *slog.Logger) *State {
+	return NewSession(srvConfig, LoadNeedsIndex(srvConfig, logger), logger)
+}
+
+// NewSession creates the state of one client connection on top of an already loaded needs index.
+func NewSession(srvConfig ServerConfig, needs *NeedsIndex, logger *slog.Logger) *State {
 	m := make(map[string]*DocumentInfo)
-	return State{Documents: m, NeedsList: needsList, ServerConfig: srvConfig, Logger: logger}
+	detector, err := NewNeedDetector(srvConfig.IDCharacters, srvConfig.TemplateStrings)
+	if err != nil {
+		logger.Warn("Using the default ID characters", "err", err)
+		detector, _ = NewNeedDetector("", srvConfig.TemplateStrings)
+	}
+	return &State{Documents: m, Needs: needs, ServerConfig: srvConfig, Logger: logger, detector: detector}
+}
+
+func (s *State) needsList() NeedsInfo {
+	return s.Needs.Needs()
 }
 
-// Need to have a check here if the document is already in the thing
+// needDetector falls back to the default ID characters for states that were not created via NewSession.
+func (s *State) needDetector() *NeedDetector {
+	if s.detector == nil {
+		return &NeedDetector{templates: s.TemplateStrings}
+	}
+	return s.detector
+}
+
+var ErrStaleVersion = errors.New("change is older than the document")
+
+// OpenDocument opens the document without a version and computes its diagnostics right away.
+// Clients go through OpenDocumentVersion, the diagnostics are computed off the reading goroutine then.
 func (s *State) OpenDocument(uri string, content string) []lsp.Diagnostic {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshDiagnostics(s.openDocument(uri, nil, content))
+}
+
+// OpenDocumentVersion opens the document with the version the client gave it.
+// Its diagnostics are only computed when they are asked for, see Diagnostics.
+func (s *State) OpenDocumentVersion(uri string, version int, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.openDocument(uri, &version, content)
+}
+
+// openDocument expects the caller to hold the lock.
+func (s *State) openDocument(uri string, version *int, content string) *DocumentInfo {
 	di, ok := s.Documents[uri] //
 	if !ok {
 		// Document not yet in our map
-		s.Logger.Printf("Document %s not found in state.documents. Initializing new DocumentInfo.", uri)
+		s.Logger.Debug("Document not found in state.documents, initializing new DocumentInfo", "uri", uri)
 		newDocInfo := &DocumentInfo{}
 		s.Documents[uri] = newDocInfo
 		di = newDocInfo
 	}
 	documentNeeds := NewDocumentNeeds(uri, s.Logger)
-	di.Content = content
-	byteContent := []byte(content)
-	ndi := FindAllNeedsPositions(byteContent, s.NeedsList)
-	diagnostics := s.FindDiagnosticsInDocument(byteContent)
+	di.setContent(content)
+	di.Version = version
+	di.revision++
+	ndi := FindAllNeedsPositions(di.doc, s.Needs.Matcher(), s.needDetector(), s.positionEncoding())
 	documentNeeds.Needs = ndi
 	di.DocumentNeeds = documentNeeds
 	di.Needs = ndi
-	di.Diagnostics = diagnostics
-	if diagnostics == nil {
-		s.Logger.Printf("I think diagnostics is empty: %v", diagnostics)
-		return []lsp.Diagnostic{}
-	}
-	return diagnostics
+	di.Diagnostics = nil
+	di.diagnosticsStale = true
+	return di
 }
 
+// UpdateDocument replaces the whole content and computes the diagnostics right away, like OpenDocument.
 func (s *State) UpdateDocument(uri string, content string) []lsp.Diagnostic {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshDiagnostics(s.updateDocument(uri, content))
+}
+
+// updateDocument replaces the whole content and marks the diagnostics as stale.
+// The caller has to hold the lock.
+func (s *State) updateDocument(uri string, content string) *DocumentInfo {
 	di, ok := s.Documents[uri] //
 	if !ok {
 		// The document doesn't exist in our map yet.
 		// This means didOpen wasn't called, or an error occurred.
 		// We need to initialize it.
-		s.Logger.Printf("Document %s not found in state.documents. Initializing new DocumentInfo.", uri)
+		s.Logger.Debug("Document not found in state.documents, initializing new DocumentInfo", "uri", uri)
 		newDocInfo := &DocumentInfo{} // Create a new DocumentInfo instance
 		s.Documents[uri] = newDocInfo // Store the pointer to the new instance
 		di = newDocInfo               // Use this new instance for current operations
 	}
-	byteContent := []byte(content)
-	ndi := FindAllNeedsPositions(byteContent, s.NeedsList)
-	diagnostics := s.FindDiagnosticsInDocument(byteContent)
-	di.Needs = ndi
-	di.Content = content
+	di.setContent(content)
+	di.Needs = FindAllNeedsPositions(di.doc, s.Needs.Matcher(), s.needDetector(), s.positionEncoding())
+	di.revision++
+	di.Diagnostics = nil
+	di.diagnosticsStale = true
+	return di
+}
+
+// refreshDiagnostics computes the diagnostics of di right away, the caller has to hold the lock.
+func (s *State) refreshDiagnostics(di *DocumentInfo) []lsp.Diagnostic {
+	// Can not fail without a context that gets cancelled
+	diagnostics, _ := s.findDiagnostics(context.Background(), di.document(), s.needsList(), s.positionEncoding())
 	di.Diagnostics = diagnostics
+	di.diagnosticsStale = false
 	if diagnostics == nil {
-		s.Logger.Printf("I think diagnostics is empty: %v", diagnostics)
+		s.Logger.Debug("No diagnostics found", "uri", di.URI)
 		return []lsp.Diagnostic{}
 	}
 	return diagnostics
 }
 
-func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
-	var diagnostics = []lsp.Diagnostic{}
+// DocumentVersion returns the version of an open document.
+// It reports false if the document is not open or the client did not give it a version.
+func (s *State) DocumentVersion(uri string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	di, ok := s.Documents[uri]
+	if !ok || di.Version == nil {
+		return 0, false
+	}
+	return *di.Version, true
+}
 
-	reader := bytes.NewReader(content)
-	scanner := bufio.NewScanner(reader)
-	lineNr := -1
+// CloseDocument forgets everything we know about the document.
+// It reports false if the document was not open.
+func (s *State) CloseDocument(uri string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Documents[uri]
+	delete(s.Documents, uri)
+	return ok
+}
 
-	for scanner.Scan() {
-		lineNr++
-		lineTxt := scanner.Text()
+// SaveDocument has the diagnostics of the document computed again, the needs.json or rst files may have changed.
+// The content is only replaced if the client sent the text, the synced buffer stays as it is otherwise:
+// the editor may have changed it, e.g. its line endings, so later changes would not fit the file on disk.
+func (s *State) SaveDocument(uri string, text *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	di, ok := s.Documents[uri]
+	if !ok {
+		return fmt.Errorf("document %s is not open", uri)
+	}
+	if text != nil {
+		s.updateDocument(uri, *text)
+		return nil
+	}
+	di.Diagnostics = nil
+	di.diagnosticsStale = true
+	return nil
+}
 
-		s.Logger.Printf("Diagnostics: Processing line %d: '%s'", lineNr, lineTxt)
+// findDiagnostics checks doc against needsList. It does not need the lock if needsList was taken
+// while holding it, shutdown drops the needs. It returns ctx.Err() if it got cancelled.
+func (s *State) findDiagnostics(ctx context.Context, doc *Document, needsList NeedsInfo, enc lsp.PositionEncodingKind) ([]lsp.Diagnostic, error) {
+	var diagnostics = []lsp.Diagnostic{}
+
+	for lineNr := 0; lineNr < doc.LineCount(); lineNr++ {
+		lineTxt := doc.Line(lineNr)
+		if lineNr%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		s.Logger.Debug("Diagnostics: processing line", "line", lineNr, "text", lineTxt)
 
 		var templateFound bool
 		var matchedTemplatePrefix string
@@ -99,19 +207,20 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 		}
 
 		if templateFound { // Only proceed if a template prefix was found
-			s.Logger.Println("We found a template string, now going further")
+			s.Logger.Debug("Diagnostics: found template string", "line", lineNr, "template", matchedTemplatePrefix)
 			contentAfterPrefix := strings.TrimPrefix(lineTxt, matchedTemplatePrefix)
 			prefixLength := len(matchedTemplatePrefix)
 			if strings.TrimSpace(contentAfterPrefix) == "" {
+				prefixChars := CharacterCount(matchedTemplatePrefix, enc)
 				diagnostics = append(diagnostics, lsp.Diagnostic{
 					Range: lsp.Range{
 						Start: lsp.Position{
 							Line:      lineNr,
-							Character: len(matchedTemplatePrefix),
+							Character: prefixChars,
 						},
 						End: lsp.Position{
 							Line:      lineNr,
-							Character: len(matchedTemplatePrefix),
+							Character: prefixChars,
 						},
 					},
 					Severity: 2,
@@ -122,7 +231,7 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 			}
 
 			potentialNeedsFound := strings.Split(contentAfterPrefix, ",")
-			s.Logger.Printf("Potential Needs is: %d for line: %d", len(potentialNeedsFound), lineNr)
+			s.Logger.Debug("Diagnostics: potential needs", "count", len(potentialNeedsFound), "line", lineNr)
 
 			currentOffsetInSuffix := 0
 
@@ -134,11 +243,12 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 					offsetWithinDirtyPart = 0
 				}
 
-				charStart := prefixLength + currentOffsetInSuffix + offsetWithinDirtyPart
-				charEnd := charStart + len(trimmedNeed)
+				byteStart := prefixLength + currentOffsetInSuffix + offsetWithinDirtyPart
+				charStart := CharacterCount(lineTxt[:byteStart], enc)
+				charEnd := charStart + CharacterCount(trimmedNeed, enc)
 
-				s.Logger.Printf("Diagnostics: On line %d, found dirty part: '%s', trimmed: '%s'. Range: [%d,%d]",
-					lineNr, drtyNeed, trimmedNeed, charStart, charEnd)
+				s.Logger.Debug("Diagnostics: found need candidate",
+					"line", lineNr, "part", drtyNeed, "need", trimmedNeed, "start", charStart, "end", charEnd)
 
 				if trimmedNeed == "" {
 					// This case handles empty parts from trailing commas or double commas (e.g., "ID1,,ID2")
@@ -149,9 +259,25 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 				}
 
 				// Check if the trimmedNeed exists in your NeedsList
-				_, ok := s.NeedsList[trimmedNeed] // Assuming s.NeedsList is map[string]Need
+				need, ok := needsList[trimmedNeed]
+				if ok && need.OnlyInVersion != "" {
+					diagnostics = append(diagnostics, lsp.Diagnostic{
+						Range: lsp.Range{
+							Start: lsp.Position{Line: lineNr, Character: charStart},
+							End:   lsp.Position{Line: lineNr, Character: charEnd},
+						},
+						Severity: 2,
+						Source:   "scl_lsp",
+						Message:  fmt.Sprintf("Need '%s' only exists in version %s of %s.", trimmedNeed, need.OnlyInVersion, need.sourceName()),
+					})
+				}
 				if !ok {
-					s.Logger.Printf("Diagnostics: Unknown need '%s' on line %d.", trimmedNeed, lineNr)
+					s.Logger.Debug("Diagnostics: unknown need", "need", trimmedNeed, "line", lineNr)
+					notFound := "not found"
+					if source := s.sourceForID(trimmedNeed); source != nil {
+						// The prefix says where it should be
+						notFound = "not found in " + source.DisplayName()
+					}
 					diagnostics = append(diagnostics, lsp.Diagnostic{
 						Range: lsp.Range{
 							Start: lsp.Position{
@@ -165,7 +291,7 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 						},
 						Severity: 1,
 						Source:   "scl_lsp",
-						Message:  fmt.Sprintf("Need '%s' not found. Typo or missing definition?", trimmedNeed),
+						Message:  fmt.Sprintf("Need '%s' %s. Typo or missing definition?", trimmedNeed, notFound),
 					})
 				}
 
@@ -176,30 +302,50 @@ func (s *State) FindDiagnosticsInDocument(content []byte) []lsp.Diagnostic {
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		s.Logger.Printf("Error scanning document content for diagnostics: %v", err)
-	}
-
-	s.Logger.Printf("FindDiagnosticsInDocument returning %d diagnostics.", len(diagnostics))
-	return diagnostics
+	s.Logger.Debug("Diagnostics done", "diagnostics", len(diagnostics))
+	return diagnostics, nil
 }
 
-func (s *State) UpdateNeedsJson(path string) {
-	needsJson := ParseNeedsJson(path, s.Logger)
-	s.NeedsList = GetNeedsList(needsJson)
+// RefreshNeeds finds the needs in all open documents again, e.g. after the needs index was reloaded.
+// It returns the URIs of the documents, their diagnostics have to be computed again.
+func (s *State) RefreshNeeds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := s.positionEncoding()
+	uris := make([]string, 0, len(s.Documents))
+	for uri, di := range s.Documents {
+		di.Needs = FindAllNeedsPositions(di.document(), s.Needs.Matcher(), s.needDetector(), enc)
+		// Diagnostics that are computed right now are for the old needs
+		di.revision++
+		di.Diagnostics = nil
+		di.diagnosticsStale = true
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
 }
 
 func (s *State) FindNeedsInRequestedPosition(docURI string, pos lsp.Position) (Need, error) {
-	docInfo := s.Documents[docURI]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findNeedInDocument(docURI, pos)
+}
+
+func (s *State) findNeedInDocument(docURI string, pos lsp.Position) (Need, error) {
+	docInfo, ok := s.Documents[docURI]
+	if !ok {
+		return Need{}, fmt.Errorf("document %s is not open", docURI)
+	}
 	// Probably can speed this up somehow
 	return docInfo.FindNeedsInPosition(pos)
 }
 
-func (s *State) GoToDefinition(id int, docURI string, pos lsp.Position) lsp.DefinitionResponse {
-	docInfo := s.Documents[docURI]
-	foundNeed, err := docInfo.FindNeedsInPosition(pos)
+func (s *State) GoToDefinition(id lsp.ID, docURI string, pos lsp.Position) lsp.DefinitionResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	foundNeed, err := s.findNeedInDocument(docURI, pos)
 	if err != nil {
-		s.Logger.Printf("Definition: Did not find need definition requested. Error: %s", err.Error())
+		s.Logger.Debug("Definition: did not find need definition requested", "err", err)
 		// Need to send error repsonse instead then in the future
 		return lsp.DefinitionResponse{
 			Response: lsp.Response{
@@ -209,75 +355,140 @@ func (s *State) GoToDefinition(id int, docURI string, pos lsp.Position) lsp.Defi
 			Result: []lsp.Location{},
 		}
 	}
-	docName := foundNeed.Docname + ".rst"
-	fnDocURI := GetURIFromDocumentName(docName, s.DocumentRootPath)
-	s.Logger.Println("Searched for need in document name")
-	s.Logger.Printf("DocURI: %s  Lineo: %d", fnDocURI, foundNeed.Lineno)
 
-	return lsp.DefinitionResponse{
+	response := lsp.DefinitionResponse{
 		Response: lsp.Response{
 			RPC: "2.0",
 			ID:  &id,
 		},
-		Result: []lsp.Location{{
-			URI: fnDocURI,
-			Range: lsp.Range{
-				Start: lsp.Position{
-					Line:      foundNeed.Lineno - 1,
-					Character: 0,
-				},
-				End: lsp.Position{
-					Line:      foundNeed.Lineno - 1,
-					Character: 0,
-				},
+		Result: []lsp.Location{},
+	}
+	if location, ok := s.definitionLocation(foundNeed); ok {
+		response.Result = append(response.Result, location)
+	}
+	return response
+}
+
+// definitionLocation points to the line of the rst file the need is defined in.
+// Needs of external sources without a docs root can not be opened.
+func (s *State) definitionLocation(need Need) (lsp.Location, bool) {
+	docsRoot := s.DocumentRootPath
+	if source := need.Source(); source != nil {
+		if source.External && source.DocsRoot == "" {
+			s.Logger.Debug("Definition: external need without docs root", "need", need.ID, "source", source.DisplayName())
+			return lsp.Location{}, false
+		}
+		docsRoot = source.DocsRoot
+	}
+	docName := need.Docname + ".rst"
+	fnDocURI := GetURIFromDocumentName(docName, docsRoot)
+	s.Logger.Debug("Definition location", "uri", fnDocURI, "lineno", need.Lineno)
+	return lsp.Location{
+		URI: fnDocURI,
+		Range: lsp.Range{
+			Start: lsp.Position{
+				Line:      need.Lineno - 1,
+				Character: 0,
+			},
+			End: lsp.Position{
+				Line:      need.Lineno - 1,
+				Character: 0,
 			},
 		},
-		}}
+	}, true
+}
+
+// References lists every trace link to the need at pos in the open documents, the need itself
+// can also be a mention. Mentions are incidental text, they are left out.
+// It returns ctx.Err() if the request got cancelled while searching.
+func (s *State) References(ctx context.Context, id lsp.ID, docURI string, pos lsp.Position, includeDeclaration bool) (lsp.ReferencesResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	response := lsp.ReferencesResponse{
+		Response: lsp.Response{RPC: "2.0", ID: &id},
+		Result:   []lsp.Location{},
+	}
+	foundNeed, err := s.findNeedInDocument(docURI, pos)
+	if err != nil {
+		s.Logger.Debug("References: no need at requested position", "err", err)
+		return response, nil
+	}
+	if location, ok := s.definitionLocation(foundNeed); ok && includeDeclaration {
+		response.Result = append(response.Result, location)
+	}
+	// Sorted, so the client gets the same order every time
+	uris := make([]string, 0, len(s.Documents))
+	for uri := range s.Documents {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		if err := ctx.Err(); err != nil {
+			return lsp.ReferencesResponse{}, err
+		}
+		for _, ndi := range s.Documents[uri].Needs {
+			if ndi.ID != foundNeed.ID {
+				continue
+			}
+			for _, p := range ndi.Positions {
+				if p.Kind != ReferenceTraceLink {
+					continue
+				}
+				response.Result = append(response.Result, lsp.Location{
+					URI: uri,
+					Range: lsp.Range{
+						Start: lsp.Position{Line: p.Line, Character: p.StartCol},
+						End:   lsp.Position{Line: p.Line, Character: p.EndCol},
+					},
+				})
+			}
+		}
+	}
+	return response, nil
 }
 
 // Make this only activate when you write one of the template strings
-func (s *State) TextDocumentCompletion(id int, docURI string, pos lsp.Position) lsp.CompletionResponse {
-	s.Logger.Printf("=== COMPLETION DEBUG ===")
+// It returns ctx.Err() if the request got cancelled while collecting the items.
+func (s *State) TextDocumentCompletion(ctx context.Context, id lsp.ID, docURI string, pos lsp.Position) (lsp.CompletionResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	needsList := s.needsList()
 	docInfo := s.Documents[docURI]
 	if docInfo == nil {
-		s.Logger.Printf("ERROR: Document not found for URI: %s", docURI)
+		s.Logger.Warn("Completion: document not found", "uri", docURI)
 		return lsp.CompletionResponse{
 			Response: lsp.Response{
 				RPC: "2.0",
 				ID:  &id,
 			},
 			Result: []lsp.CompletionItem{},
-		}
+		}, nil
 	}
-	s.Logger.Printf("Position: Line=%d, Character=%d\n", pos.Line, pos.Character)
-	s.Logger.Printf("Document found, content length: %d\n", len(docInfo.Content))
-	s.Logger.Printf("Raw document content: %q\n", docInfo.Content)
-	lines := strings.Split(docInfo.Content, "\n")
+	s.Logger.Debug("Completion requested", "uri", docURI, "line", pos.Line, "character", pos.Character, "contentLength", len(docInfo.Content))
+	doc := docInfo.document()
 	completionLine := ""
-	if int(pos.Line) < len(lines) {
-		completionLine = lines[pos.Line]
-	} else if int(pos.Line) == len(lines) {
-		s.Logger.Printf("Cursor is on a logically new line (%d), treating as empty.", pos.Line)
+	if pos.Line < doc.LineCount() {
+		completionLine = doc.Line(pos.Line)
+	} else if pos.Line == doc.LineCount() {
+		s.Logger.Debug("Completion: cursor is on a logically new line, treating as empty", "line", pos.Line)
 		completionLine = "" // It's an empty line
 	} else {
-		s.Logger.Printf("Completion ERROR: Line %d is significantly out of bounds (doc has %d lines). Returning empty.", pos.Line, len(lines))
-		s.Logger.Printf("Current document content state:\n---\n%q\n---", docInfo.Content)
+		s.Logger.Warn("Completion: line is out of bounds, returning empty", "line", pos.Line, "lines", doc.LineCount())
 		return lsp.CompletionResponse{
 			Response: lsp.Response{RPC: "2.0", ID: &id},
 			Result:   []lsp.CompletionItem{},
-		}
+		}, nil
 	}
 	linePrefix := ""
-	if int(pos.Character) <= len(completionLine) { // Use <= here, if cursor is *at* the end of line
-		linePrefix = completionLine[:pos.Character]
+	if pos.Character <= CharacterCount(completionLine, s.positionEncoding()) { // Use <= here, if cursor is *at* the end of line
+		linePrefix = completionLine[:ByteOffset(completionLine, pos.Character, s.positionEncoding())]
 	} else {
 		// This case means pos.Character is beyond the actual length of the text on the line.
 		// It implies the user typed past the end or the line is still empty but they moved cursor.
 		// We'll treat linePrefix as the entire content of the line, even if character is invalid.
 		linePrefix = completionLine
-		s.Logger.Printf("Cursor character %d is beyond line content length %d. Using full line as prefix.", pos.Character, len(completionLine))
+		s.Logger.Debug("Completion: cursor is beyond line content, using full line as prefix", "character", pos.Character, "lineLength", len(completionLine))
 	}
-	s.Logger.Printf("linePrefix: '%s'", linePrefix)
 
 	// toBeCompletedItem should be the fragment *after* the last space/word boundary
 	// This is useful for filtering specific keywords (like "req-" or "tool_")
@@ -286,22 +497,12 @@ func (s *State) TextDocumentCompletion(id int, docURI string, pos lsp.Position)
 	if lastSpace != -1 {
 		toBeCompletedItem = linePrefix[lastSpace+1:]
 	}
-	s.Logger.Printf("toBeCompletedItem: '%s'", toBeCompletedItem)
+	s.Logger.Debug("Completion prefix", "linePrefix", linePrefix, "toBeCompletedItem", toBeCompletedItem)
 
 	// Label = What we want to complete
 	var items []lsp.CompletionItem
-	hasReqPrefix := strings.HasPrefix(toBeCompletedItem, "# req-")
-	hasTraceability := strings.Contains(completionLine, "# req-traceability:")
-	hasReqId := strings.Contains(completionLine, "# req-Id:")
-
-	s.Logger.Printf("Condition 1 - HasPrefix('# req-'): %v", hasReqPrefix)
-	s.Logger.Printf("Condition 2a - Contains('# req-traceability:'): %v", hasTraceability)
-	s.Logger.Printf("Condition 2b - Contains('# req-Id:'): %v", hasReqId)
-	s.Logger.Printf("NeedsList length: %d", len(s.NeedsList))
 	// NeedToCheck if this is okay.
 	// TODO: Pre-compute this once? Might be nicer to do this.
-	//s.Logger.Printf("This is toBeCompletedItem: %v", toBeCompletedItem)
-	s.Logger.Printf("To be completed item: %+v", toBeCompletedItem)
 	if strings.HasPrefix(toBeCompletedItem, "req-") {
 		items = append(items, lsp.CompletionItem{
 			Label:            "req-Id:",
@@ -318,7 +519,6 @@ func (s *State) TextDocumentCompletion(id int, docURI string, pos lsp.Position)
 			InsertTextFormat: 2,
 		})
 	}
-	s.Logger.Printf("NeedsList after case 1. length: %d", len(s.NeedsList))
 	if strings.Contains(linePrefix, "req-Id: ") || strings.Contains(linePrefix, "req-traceability: ") {
 		// Find what comes after the colon and space
 		var afterColon string
@@ -333,22 +533,27 @@ func (s *State) TextDocumentCompletion(id int, docURI string, pos lsp.Position)
 
 		// If nothing typed yet, show all needs
 		if afterColon == "" {
-			for _, need := range s.NeedsList {
+			for _, need := range needsList {
+				if err := ctx.Err(); err != nil {
+					return lsp.CompletionResponse{}, err
+				}
 				items = append(items, need.GenerateCompletionInfo())
 			}
 		} else {
 			// Filter needs based on what's already typed
-			for _, need := range s.NeedsList {
+			for _, need := range needsList {
+				if err := ctx.Err(); err != nil {
+					return lsp.CompletionResponse{}, err
+				}
 				if strings.HasPrefix(strings.ToLower(need.ID), strings.ToLower(afterColon)) {
 					items = append(items, need.GenerateCompletionInfo())
 				}
 			}
 		}
 	}
-	s.Logger.Printf("Final items count: %d", len(items))
-	s.Logger.Printf("=== END COMPLETION DEBUG ===")
+	s.Logger.Debug("Completion done", "items", len(items))
 	return lsp.CompletionResponse{
 		Response: lsp.Response{RPC: "2.0", ID: &id},
 		Result:   items,
-	}
+	}, nil
 }
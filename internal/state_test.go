@@ -1,8 +1,10 @@
 package internal
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"sclls/lsp"
 	"testing"
 )
@@ -11,7 +13,7 @@ import (
 
 // Helper function to create a test state
 func createTestState() State {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	config := ServerConfig{
 		NeedsJsonPath:    "/test/needs.json",
 		DocumentRootPath: "/test/docs",
@@ -27,7 +29,7 @@ func createTestState() State {
 
 	return State{
 		Documents:    make(map[string]*DocumentInfo),
-		NeedsList:    needsList,
+		Needs:        NewNeedsIndex(needsList),
 		ServerConfig: config,
 		Logger:       logger,
 	}
@@ -62,7 +64,7 @@ func TestNewState(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 			if tt.expectPanic {
 				defer func() {
@@ -210,8 +212,7 @@ func TestUpdateDocument(t *testing.T) {
 	}
 }
 
-// Tests for FindDiagnosticsInDocument
-func TestFindDiagnosticsInDocument(t *testing.T) {
+func TestFindDiagnostics(t *testing.T) {
 	tests := []struct {
 		name        string
 		content     string
@@ -247,7 +248,7 @@ func TestFindDiagnosticsInDocument(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			state := createTestState()
-			diagnostics := state.FindDiagnosticsInDocument([]byte(tt.content))
+			diagnostics := state.OpenDocument("file:///test.py", tt.content)
 
 			if len(diagnostics) != tt.expectedNum {
 				t.Errorf("%s: Expected %d diagnostics, got %d", tt.description, tt.expectedNum, len(diagnostics))
@@ -313,7 +314,10 @@ func TestTextDocumentCompletion(t *testing.T) {
 			// Setup document
 			state.OpenDocument(uri, tt.content)
 
-			response := state.TextDocumentCompletion(1, uri, tt.position)
+			response, err := state.TextDocumentCompletion(context.Background(), lsp.NewIntID(1), uri, tt.position)
+			if err != nil {
+				t.Fatalf("TextDocumentCompletion() unexpected error = %v", err)
+			}
 
 			if len(response.Result) != tt.expectedItems {
 				t.Errorf("%s: Expected %d completion items, got %d", tt.description, tt.expectedItems, len(response.Result))
@@ -323,7 +327,7 @@ func TestTextDocumentCompletion(t *testing.T) {
 			if response.Response.RPC != "2.0" {
 				t.Error("Expected RPC version 2.0")
 			}
-			if response.Response.ID == nil || *response.Response.ID != 1 {
+			if response.Response.ID == nil || *response.Response.ID != lsp.NewIntID(1) {
 				t.Error("Expected response ID to be 1")
 			}
 		})
@@ -377,7 +381,7 @@ func TestGoToDefinition(t *testing.T) {
 			// Setup document
 			state.OpenDocument(uri, tt.content)
 
-			response := state.GoToDefinition(1, uri, tt.position)
+			response := state.GoToDefinition(lsp.NewIntID(1), uri, tt.position)
 
 			if len(response.Result) != tt.expectedResults {
 				t.Errorf("%s: Expected %d results, got %d", tt.description, tt.expectedResults, len(response.Result))
@@ -387,7 +391,7 @@ func TestGoToDefinition(t *testing.T) {
 			if response.Response.RPC != "2.0" {
 				t.Error("Expected RPC version 2.0")
 			}
-			if response.Response.ID == nil || *response.Response.ID != 1 {
+			if response.Response.ID == nil || *response.Response.ID != lsp.NewIntID(1) {
 				t.Error("Expected response ID to be 1")
 			}
 
@@ -403,3 +407,107 @@ func TestGoToDefinition(t *testing.T) {
 		})
 	}
 }
+
+// Tests for References
+func TestReferences(t *testing.T) {
+	state := createTestState()
+	state.OpenDocument("file:///a.py", "# req-Id: REQ_001\n# req-Id: REQ_002, REQ_001")
+	state.OpenDocument("file:///b.py", "# req-traceability: REQ_001\n# REQ_001 is only mentioned here")
+
+	tests := []struct {
+		name               string
+		position           lsp.Position
+		includeDeclaration bool
+		expectedResults    int
+	}{
+		{
+			name:            "references across documents",
+			position:        lsp.Position{Line: 0, Character: 12},
+			expectedResults: 3,
+		},
+		{
+			name:               "including the declaration",
+			position:           lsp.Position{Line: 0, Character: 12},
+			includeDeclaration: true,
+			expectedResults:    4,
+		},
+		{
+			name:            "no need at position",
+			position:        lsp.Position{Line: 0, Character: 2},
+			expectedResults: 0,
+		},
+	}
+	// Found from a mention as well
+	if response, err := state.References(context.Background(), lsp.NewIntID(1), "file:///b.py", lsp.Position{Line: 1, Character: 3}, false); err != nil || len(response.Result) != 3 {
+		t.Errorf("References() from a mention = %v (err %v), want the 3 trace links", response.Result, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := state.References(context.Background(), lsp.NewIntID(1), "file:///a.py", tt.position, tt.includeDeclaration)
+			if err != nil {
+				t.Fatalf("References() unexpected error = %v", err)
+			}
+			if len(response.Result) != tt.expectedResults {
+				t.Errorf("Expected %d references, got %d", tt.expectedResults, len(response.Result))
+			}
+		})
+	}
+}
+
+func TestCancelledRequests(t *testing.T) {
+	state := createTestState()
+	uri := "file:///test.rst"
+	state.OpenDocument(uri, "# req-Id: REQ_001")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := state.TextDocumentCompletion(ctx, lsp.NewIntID(1), uri, lsp.Position{Line: 0, Character: 10}); err != context.Canceled {
+		t.Errorf("TextDocumentCompletion() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := state.References(ctx, lsp.NewIntID(2), uri, lsp.Position{Line: 0, Character: 12}, false); err != context.Canceled {
+		t.Errorf("References() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCloseAndSaveDocument(t *testing.T) {
+	state := createTestState()
+	if state.CloseDocument("file:///never-opened.py") {
+		t.Error("Expected CloseDocument to report a document that was never opened")
+	}
+
+	path := filepath.Join(t.TempDir(), "saved.py")
+	uri := "file://" + filepath.ToSlash(path)
+	state.OpenDocument(uri, "# req-Id: REQ_001")
+	if err := os.WriteFile(path, []byte("# req-Id: MISSING"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.SaveDocument(uri, nil); err != nil {
+		t.Fatalf("SaveDocument() unexpected error = %v", err)
+	}
+	diagnostics, _, err := state.Diagnostics(context.Background(), uri)
+	if err != nil || len(diagnostics) != 0 || state.Documents[uri].Content != "# req-Id: REQ_001" {
+		t.Errorf("Expected the synced content to be kept, got %d diagnostics for %q (err %v)", len(diagnostics), state.Documents[uri].Content, err)
+	}
+	text := "# req-Id: REQ_002"
+	if err := state.SaveDocument(uri, &text); err != nil {
+		t.Fatalf("SaveDocument() unexpected error = %v", err)
+	}
+	if diagnostics, _, err = state.Diagnostics(context.Background(), uri); err != nil || len(diagnostics) != 0 {
+		t.Errorf("Expected the sent text to be used, got %v (err %v)", diagnostics, err)
+	}
+
+	if !state.CloseDocument(uri) {
+		t.Error("Expected CloseDocument to find the open document")
+	}
+	if _, ok := state.Documents[uri]; ok {
+		t.Error("Expected the document to be evicted")
+	}
+	if err := state.SaveDocument(uri, &text); err == nil {
+		t.Error("Expected an error saving a closed document")
+	}
+	if err := state.SaveDocument("untitled:Untitled-1", nil); err == nil {
+		t.Error("Expected an error saving a document that is not open")
+	}
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sclls/lsp"
+)
+
+// ChangeDocument applies the changes of one didChange notification in order.
+// Ranged changes only recompute the lines they touch, a change without range replaces the whole content.
+// Versions have to increase, a change that is not newer than the document returns ErrStaleVersion.
+func (s *State) ChangeDocument(uri string, version int, changes []lsp.TextDocumentContentChangeEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	di, ok := s.Documents[uri]
+	if ok && di.Version != nil && version <= *di.Version {
+		return fmt.Errorf("%w: got version %d, have %d", ErrStaleVersion, version, *di.Version)
+	}
+	for _, change := range changes {
+		if change.Range == nil {
+			di, ok = s.updateDocument(uri, change.Text), true
+			continue
+		}
+		if !ok {
+			// We can not apply a part of a document we never saw
+			return fmt.Errorf("document %s is not open, can not apply a ranged change", uri)
+		}
+		if err := s.applyRangedChange(di, *change.Range, change.Text); err != nil {
+			return err
+		}
+	}
+	if ok {
+		di.Version = &version
+	}
+	return nil
+}
+
+// applyRangedChange replaces rng with text and updates the needs and diagnostics of the touched lines.
+// The caller has to hold the lock.
+func (s *State) applyRangedChange(di *DocumentInfo, rng lsp.Range, text string) error {
+	if rng.End.Line < rng.Start.Line || rng.End.Line == rng.Start.Line && rng.End.Character < rng.Start.Character {
+		return fmt.Errorf("invalid range %d:%d-%d:%d", rng.Start.Line, rng.Start.Character, rng.End.Line, rng.End.Character)
+	}
+	enc := s.positionEncoding()
+	doc := di.document()
+	start := doc.Offset(rng.Start, enc)
+	end := doc.Offset(rng.End, enc)
+	di.setContent(di.Content[:start] + text + di.Content[end:])
+	di.revision++
+
+	firstLine := rng.Start.Line
+	removedLines := rng.End.Line - rng.Start.Line
+	addedLines := strings.Count(text, "\n")
+	lastLine := firstLine + addedLines
+	delta := addedLines - removedLines
+
+	changed := NewDocument(di.doc.Lines(firstLine, lastLine))
+	needs := FindAllNeedsPositions(changed, s.Needs.Matcher(), s.needDetector(), enc)
+	for i := range needs {
+		for j := range needs[i].Positions {
+			needs[i].Positions[j].Line += firstLine
+		}
+	}
+	oldLast := rng.End.Line
+	di.Needs = mergeNeeds(di.Needs, needs, firstLine, oldLast, delta)
+
+	if di.diagnosticsStale {
+		// All of them get computed again anyway
+		return nil
+	}
+	// Can not fail without a context that gets cancelled
+	diagnostics, _ := s.findDiagnostics(context.Background(), changed, s.needsList(), enc)
+	for i := range diagnostics {
+		diagnostics[i].Range.Start.Line += firstLine
+		diagnostics[i].Range.End.Line += firstLine
+	}
+	di.Diagnostics = mergeDiagnostics(di.Diagnostics, diagnostics, firstLine, oldLast, delta)
+	return nil
+}
+
+// mergeNeeds drops the positions on the old lines first to oldLast, moves the ones after by delta
+// and adds the freshly found ones.
+func mergeNeeds(current []NeedDocInfo, found []NeedDocInfo, first, oldLast, delta int) []NeedDocInfo {
+	byID := make(map[string]int, len(current))
+	var merged []NeedDocInfo
+	for _, ndi := range current {
+		var positions []NeedPositionInfo
+		for _, p := range ndi.Positions {
+			switch {
+			case p.Line < first:
+				positions = append(positions, p)
+			case p.Line > oldLast:
+				p.Line += delta
+				positions = append(positions, p)
+			}
+		}
+		byID[ndi.ID] = len(merged)
+		ndi.Positions = positions
+		merged = append(merged, ndi)
+	}
+	for _, ndi := range found {
+		idx, ok := byID[ndi.ID]
+		if !ok {
+			byID[ndi.ID] = len(merged)
+			merged = append(merged, ndi)
+			continue
+		}
+		merged[idx].Positions = append(merged[idx].Positions, ndi.Positions...)
+	}
+	result := merged[:0]
+	for _, ndi := range merged {
+		if len(ndi.Positions) == 0 {
+			continue
+		}
+		sort.Slice(ndi.Positions, func(i, j int) bool {
+			a, b := ndi.Positions[i], ndi.Positions[j]
+			return a.Line < b.Line || a.Line == b.Line && a.StartCol < b.StartCol
+		})
+		result = append(result, ndi)
+	}
+	return result
+}
+
+// mergeDiagnostics works like mergeNeeds, the result stays sorted by line.
+func mergeDiagnostics(current []lsp.Diagnostic, found []lsp.Diagnostic, first, oldLast, delta int) []lsp.Diagnostic {
+	merged := []lsp.Diagnostic{}
+	for _, d := range current {
+		switch {
+		case d.Range.Start.Line < first:
+			merged = append(merged, d)
+		case d.Range.Start.Line > oldLast:
+			d.Range.Start.Line += delta
+			d.Range.End.Line += delta
+			merged = append(merged, d)
+		}
+	}
+	merged = append(merged, found...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Range.Start.Line < merged[j].Range.Start.Line
+	})
+	return merged
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sclls/lsp"
+)
+
+func change(startLine, startChar, endLine, endChar int, text string) lsp.TextDocumentContentChangeEvent {
+	return lsp.TextDocumentContentChangeEvent{
+		Range: &lsp.Range{
+			Start: lsp.Position{Line: startLine, Character: startChar},
+			End:   lsp.Position{Line: endLine, Character: endChar},
+		},
+		Text: text,
+	}
+}
+
+// needPositions flattens the needs of a document, so the order of the needs does not matter.
+func needPositions(di *DocumentInfo) []string {
+	var positions []string
+	for _, ndi := range di.Needs {
+		for _, p := range ndi.Positions {
+			positions = append(positions, fmt.Sprintf("%s@%d:%d-%d", ndi.ID, p.Line, p.StartCol, p.EndCol))
+		}
+	}
+	sort.Strings(positions)
+	return positions
+}
+
+func TestChangeDocument(t *testing.T) {
+	initial := "line zero\n# req-Id: REQ_001\nmiddle\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend"
+	tests := []struct {
+		name     string
+		changes  []lsp.TextDocumentContentChangeEvent
+		expected string
+	}{
+		{
+			name:     "insert on a line",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(2, 6, 2, 6, " REQ_002")},
+			expected: "line zero\n# req-Id: REQ_001\nmiddle REQ_002\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend",
+		},
+		{
+			name:     "insert lines before needs",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(0, 0, 0, 0, "new\n# req-Id: \n")},
+			expected: "new\n# req-Id: \nline zero\n# req-Id: REQ_001\nmiddle\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend",
+		},
+		{
+			name:     "delete lines",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(1, 0, 3, 0, "")},
+			expected: "line zero\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend",
+		},
+		{
+			name:     "join lines",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(2, 6, 3, 0, "")},
+			expected: "line zero\n# req-Id: REQ_001\nmiddle# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend",
+		},
+		{
+			name:     "fix unknown need",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(3, 10, 3, 17, "REQ_001")},
+			expected: "line zero\n# req-Id: REQ_001\nmiddle\n# req-Id: REQ_001\n# req-Id: REQ_002, TOOL_001\nend",
+		},
+		{
+			name: "several changes in order",
+			changes: []lsp.TextDocumentContentChangeEvent{
+				change(5, 3, 5, 3, "\n# req-Id: TYPO"),
+				change(1, 0, 2, 0, ""),
+				change(0, 0, 0, 4, "LINE"),
+			},
+			expected: "LINE zero\nmiddle\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\nend\n# req-Id: TYPO",
+		},
+		{
+			name:     "range past the end is clamped",
+			changes:  []lsp.TextDocumentContentChangeEvent{change(5, 1, 40, 0, "")},
+			expected: "line zero\n# req-Id: REQ_001\nmiddle\n# req-Id: MISSING\n# req-Id: REQ_002, TOOL_001\ne",
+		},
+		{
+			name:     "full change",
+			changes:  []lsp.TextDocumentContentChangeEvent{{Text: "# req-Id: REQ_002"}, change(0, 10, 0, 17, "REQ_404")},
+			expected: "# req-Id: REQ_404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := createTestState()
+			state.OpenDocument("file:///test.py", initial)
+			if err := state.ChangeDocument("file:///test.py", 2, tt.changes); err != nil {
+				t.Fatalf("ChangeDocument() unexpected error = %v", err)
+			}
+			diagnostics, version, err := state.Diagnostics(context.Background(), "file:///test.py")
+			if err != nil || version == nil || *version != 2 {
+				t.Fatalf("Diagnostics() = version %v, error %v, want version 2", version, err)
+			}
+			doc := state.Documents["file:///test.py"]
+			if doc.Content != tt.expected {
+				t.Fatalf("Content = %q, want %q", doc.Content, tt.expected)
+			}
+
+			// Has to end up exactly where parsing the whole document gets us
+			full := createTestState()
+			fullDiagnostics := full.OpenDocument("file:///test.py", tt.expected)
+			if !reflect.DeepEqual(diagnostics, fullDiagnostics) {
+				t.Errorf("Diagnostics = %v, want %v", diagnostics, fullDiagnostics)
+			}
+			if got, want := needPositions(doc), needPositions(full.Documents["file:///test.py"]); !reflect.DeepEqual(got, want) {
+				t.Errorf("Needs = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChangeDocumentErrors(t *testing.T) {
+	state := createTestState()
+	if err := state.ChangeDocument("file:///unknown.py", 2, []lsp.TextDocumentContentChangeEvent{change(0, 0, 0, 0, "x")}); err == nil {
+		t.Error("Expected an error for a ranged change of a document that is not open")
+	}
+	state.OpenDocument("file:///test.py", "abc\ndef")
+	if err := state.ChangeDocument("file:///test.py", 2, []lsp.TextDocumentContentChangeEvent{change(1, 0, 0, 0, "x")}); err == nil {
+		t.Error("Expected an error for a range that ends before it starts")
+	}
+}
+
+func TestChangeDocumentVersions(t *testing.T) {
+	state := createTestState()
+	uri := "file:///test.py"
+	state.OpenDocumentVersion(uri, 3, "# req-Id: REQ_001")
+	full := func(text string) []lsp.TextDocumentContentChangeEvent {
+		return []lsp.TextDocumentContentChangeEvent{{Text: text}}
+	}
+
+	if err := state.ChangeDocument(uri, 4, full("# req-Id: REQ_002")); err != nil {
+		t.Fatalf("ChangeDocument() unexpected error = %v", err)
+	}
+	for _, version := range []int{4, 2} {
+		if err := state.ChangeDocument(uri, version, full("# req-Id: OLD")); !errors.Is(err, ErrStaleVersion) {
+			t.Errorf("ChangeDocument() with version %d error = %v, want %v", version, err, ErrStaleVersion)
+		}
+	}
+	if version, _ := state.DocumentVersion(uri); version != 4 || state.Documents[uri].Content != "# req-Id: REQ_002" {
+		t.Errorf("Expected version 4 to be kept, got version %d with %q", version, state.Documents[uri].Content)
+	}
+
+	// 0 is a version like any other
+	state.OpenDocumentVersion("file:///zero.py", 0, "# req-Id: REQ_001")
+	if err := state.ChangeDocument("file:///zero.py", 0, full("# req-Id: OLD")); !errors.Is(err, ErrStaleVersion) {
+		t.Errorf("ChangeDocument() with version 0 error = %v, want %v", err, ErrStaleVersion)
+	}
+	if _, version, err := state.Diagnostics(context.Background(), "file:///zero.py"); err != nil || version == nil || *version != 0 {
+		t.Errorf("Diagnostics() = version %v, error %v, want version 0", version, err)
+	}
+
+	// Without a known version everything is accepted
+	state.OpenDocument("file:///unversioned.py", "")
+	if err := state.ChangeDocument("file:///unversioned.py", 1, full("x")); err != nil {
+		t.Errorf("ChangeDocument() unexpected error = %v", err)
+	}
+	if version, ok := state.DocumentVersion("file:///unversioned.py"); !ok || version != 1 {
+		t.Errorf("DocumentVersion() = %d, %v, want 1, true", version, ok)
+	}
+	state.OpenDocument("file:///unversioned.py", "")
+	if _, version, err := state.Diagnostics(context.Background(), "file:///unversioned.py"); err != nil || version != nil {
+		t.Errorf("Diagnostics() = version %v, error %v, want no version", version, err)
+	}
+}
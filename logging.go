@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// newLogger creates the logger of the server. Without a file it logs to stderr,
+// stdout is reserved for the protocol.
+func newLogger(file string, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, use debug, info, warn or error", level)
+	}
+	var out io.Writer = os.Stderr
+	if file != "" {
+		logfile, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, err
+		}
+		out = logfile
+	}
+	handler := slog.NewTextHandler(out, &slog.HandlerOptions{Level: lvl, AddSource: lvl <= slog.LevelDebug})
+	return slog.New(handler), nil
+}
+
+// clientLogHandler forwards records of at least minLevel to the editor via window/logMessage.
+// Everything is still handed to the wrapped handler as well.
+type clientLogHandler struct {
+	next     slog.Handler
+	writer   *rpc.Writer
+	minLevel slog.Level
+	attrs    []slog.Attr
+}
+
+func newClientLogHandler(next slog.Handler, writer *rpc.Writer, minLevel slog.Level) *clientLogHandler {
+	return &clientLogHandler{next: next, writer: writer, minLevel: minLevel}
+}
+
+func (h *clientLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.minLevel || h.next.Enabled(ctx, level)
+}
+
+func (h *clientLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.minLevel {
+		// Errors are ignored on purpose, logging them would end up here again
+		h.writer.Write(lsp.LogMessageNotification{
+			Notification: lsp.Notification{RPC: "2.0", Method: "window/logMessage"},
+			Params: lsp.LogMessageParams{
+				Type:    messageType(r.Level),
+				Message: h.format(r),
+			},
+		})
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *clientLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &clientLogHandler{
+		next:     h.next.WithAttrs(attrs),
+		writer:   h.writer,
+		minLevel: h.minLevel,
+		attrs:    append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+	}
+}
+
+func (h *clientLogHandler) WithGroup(name string) slog.Handler {
+	return &clientLogHandler{
+		next:     h.next.WithGroup(name),
+		writer:   h.writer,
+		minLevel: h.minLevel,
+		attrs:    h.attrs,
+	}
+}
+
+// format renders a record as 'message key=value ...' for the editor.
+func (h *clientLogHandler) format(r slog.Record) string {
+	var sb strings.Builder
+	sb.WriteString(r.Message)
+	writeAttr := func(attr slog.Attr) bool {
+		fmt.Fprintf(&sb, " %s=%s", attr.Key, attr.Value.String())
+		return true
+	}
+	for _, attr := range h.attrs {
+		writeAttr(attr)
+	}
+	r.Attrs(writeAttr)
+	return sb.String()
+}
+
+func messageType(level slog.Level) lsp.MessageType {
+	switch {
+	case level >= slog.LevelError:
+		return lsp.MessageTypeError
+	case level >= slog.LevelWarn:
+		return lsp.MessageTypeWarning
+	case level >= slog.LevelInfo:
+		return lsp.MessageTypeInfo
+	}
+	return lsp.MessageTypeLog
+}
+
+// traceMessages reports every handled message via $/logTrace while the client asks for traces.
+func traceMessages(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (any, error) {
+		start := time.Now()
+		response, err := next(ctx, s, msg)
+		trace := s.traceValue()
+		if trace == lsp.TraceOff {
+			return response, err
+		}
+		kind := "notification"
+		if msg.id != nil {
+			kind = fmt.Sprintf("request %s", msg.id)
+		}
+		params := lsp.LogTraceParams{
+			Message: fmt.Sprintf("Handled %s '%s' in %s", kind, rt.method, time.Since(start)),
+		}
+		if trace == lsp.TraceVerbose {
+			params.Verbose = string(msg.contents)
+			if err != nil {
+				params.Verbose += "\nError: " + err.Error()
+			}
+		}
+		writeResponse(s.writer, lsp.LogTraceNotification{
+			Notification: lsp.Notification{RPC: "2.0", Method: "$/logTrace"},
+			Params:       params,
+		})
+		return response, err
+	}
+}
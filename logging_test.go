@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sclls/internal"
+	"sclls/rpc"
+)
+
+func TestNewLogger(t *testing.T) {
+	if _, err := newLogger("", "loud"); err == nil {
+		t.Error("Expected an error for an unknown log level")
+	}
+	logFile := filepath.Join(t.TempDir(), "sclls.log")
+	logger, err := newLogger(logFile, "warn")
+	if err != nil {
+		t.Fatalf("newLogger() unexpected error = %v", err)
+	}
+	if logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Expected info to be disabled at level warn")
+	}
+	if _, err := newLogger(filepath.Join(t.TempDir(), "missing", "sclls.log"), "info"); err == nil {
+		t.Error("Expected an error for a log file that can not be created")
+	}
+}
+
+func TestClientLogHandler(t *testing.T) {
+	var out, logs bytes.Buffer
+	base := slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelError})
+	logger := slog.New(newClientLogHandler(base, rpc.NewWriter(&out), slog.LevelWarn)).With("session", 1)
+
+	logger.Info("not forwarded")
+	if out.Len() != 0 {
+		t.Errorf("Expected info not to be forwarded, got %s", out.String())
+	}
+	logger.Warn("needs.json missing", "path", "/tmp/needs.json")
+	if !strings.Contains(out.String(), `"method":"window/logMessage"`) || !strings.Contains(out.String(), `"type":2`) {
+		t.Errorf("Expected a window/logMessage warning, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "needs.json missing session=1 path=/tmp/needs.json") {
+		t.Errorf("Expected message with attributes, got %s", out.String())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected the warning not to reach the error level handler, got %s", logs.String())
+	}
+	logger.Error("boom")
+	if !strings.Contains(logs.String(), "boom") {
+		t.Errorf("Expected the error to reach the wrapped handler, got %s", logs.String())
+	}
+}
+
+func TestSetTrace(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	state := internal.NewState(internal.ServerConfig{}, logger)
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(&out), state)
+
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"trace":"messages"}}`))
+	if !strings.Contains(out.String(), `"method":"$/logTrace"`) {
+		t.Errorf("Expected a $/logTrace after initialize with trace messages, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("$/setTrace", []byte(`{"jsonrpc":"2.0","method":"$/setTrace","params":{"value":"verbose"}}`))
+	out.Reset()
+	d.dispatch("initialized", []byte(`{"jsonrpc":"2.0","method":"initialized","params":{}}`))
+	if !strings.Contains(out.String(), `"verbose":"{\"jsonrpc\"`) {
+		t.Errorf("Expected a verbose $/logTrace, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("$/setTrace", []byte(`{"jsonrpc":"2.0","method":"$/setTrace","params":{"value":"off"}}`))
+	out.Reset()
+	d.dispatch("exit", []byte(`{"jsonrpc":"2.0","method":"exit"}`))
+	if out.Len() != 0 {
+		t.Errorf("Expected no traces when trace is off, got %s", out.String())
+	}
+}
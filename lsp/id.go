@@ -0,0 +1,68 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ID is the id of a request. The spec allows integers as well as strings,
+// the value is kept exactly as it was sent so that replies echo it unchanged.
+type ID struct {
+	value    string
+	isString bool
+}
+
+func NewIntID(n int) ID {
+	return ID{value: strconv.Itoa(n)}
+}
+
+func NewStringID(s string) ID {
+	return ID{value: s, isString: true}
+}
+
+func (id ID) IsString() bool {
+	return id.isString
+}
+
+// String returns the id for logging. String ids are quoted to tell "1" from 1.
+func (id ID) String() string {
+	if id.isString {
+		return strconv.Quote(id.value)
+	}
+	return id.value
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.isString {
+		return json.Marshal(id.value)
+	}
+	if id.value == "" {
+		return nil, errors.New("can not marshal empty request id")
+	}
+	return []byte(id.value), nil
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = NewStringID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return errors.New("request id must be a number or a string")
+	}
+	// JSON numbers can have a fraction or an exponent, ids may only be integers
+	if strings.ContainsAny(n.String(), ".eE") {
+		return errors.New("request id must be an integer or a string")
+	}
+	*id = ID{value: n.String()}
+	return nil
+}
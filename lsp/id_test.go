@@ -0,0 +1,75 @@
+package lsp_test
+
+import (
+	"encoding/json"
+	"sclls/lsp"
+	"testing"
+)
+
+func TestIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantIsString bool
+		wantErr      bool
+	}{
+		{name: "integer", input: `7`},
+		{name: "big integer", input: `9007199254740993`},
+		{name: "string", input: `"abc-1"`, wantIsString: true},
+		{name: "numeric string", input: `"1"`, wantIsString: true},
+		{name: "object", input: `{"id":1}`, wantErr: true},
+		{name: "bool", input: `true`, wantErr: true},
+		{name: "fraction", input: `1.5`, wantErr: true},
+		{name: "exponent", input: `1e3`, wantErr: true},
+		{name: "negative integer", input: `-3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id lsp.ID
+			err := json.Unmarshal([]byte(tt.input), &id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if id.IsString() != tt.wantIsString {
+				t.Errorf("IsString() = %v, want %v", id.IsString(), tt.wantIsString)
+			}
+			out, err := json.Marshal(id)
+			if err != nil {
+				t.Fatalf("Marshal() unexpected error = %v", err)
+			}
+			if string(out) != tt.input {
+				t.Errorf("Marshal() = %s, want %s", out, tt.input)
+			}
+		})
+	}
+}
+
+func TestIDComparison(t *testing.T) {
+	if lsp.NewIntID(1) == lsp.NewStringID("1") {
+		t.Error("Expected integer and string id to differ")
+	}
+	var id lsp.ID
+	if err := json.Unmarshal([]byte(`1`), &id); err != nil {
+		t.Fatal(err)
+	}
+	if id != lsp.NewIntID(1) {
+		t.Errorf("Expected %s to equal NewIntID(1)", id)
+	}
+}
+
+func TestResponseWithStringID(t *testing.T) {
+	id := lsp.NewStringID("req-42")
+	resp := lsp.NewErrorResponse(&id, lsp.NewResponseError(lsp.MethodNotFound, "nope"))
+	out, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"jsonrpc":"2.0","id":"req-42","error":{"code":-32601,"message":"nope"}}`
+	if string(out) != expected {
+		t.Errorf("Expected: %s, Actual: %s", expected, out)
+	}
+}
@@ -6,10 +6,44 @@ type InitializeRequest struct {
 }
 
 type InitializeRequestParams struct {
-	ClientInfo *ClientInfo `json:"clientInfo"`
+	ClientInfo   *ClientInfo        `json:"clientInfo"`
+	Capabilities ClientCapabilities `json:"capabilities"`
+	Trace        TraceValue         `json:"trace,omitempty"`
 	// Tons of stuff missing here
 }
 
+// ClientCapabilities only holds the parts we look at.
+type ClientCapabilities struct {
+	General   *GeneralClientCapabilities   `json:"general,omitempty"`
+	Workspace *WorkspaceClientCapabilities `json:"workspace,omitempty"`
+}
+
+type WorkspaceClientCapabilities struct {
+	DidChangeWatchedFiles *DynamicRegistrationCapabilities `json:"didChangeWatchedFiles,omitempty"`
+}
+
+// DynamicRegistrationCapabilities tell if the client supports client/registerCapability for a method.
+type DynamicRegistrationCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration"`
+}
+
+type GeneralClientCapabilities struct {
+	// In order of preference of the client
+	PositionEncodings []PositionEncodingKind `json:"positionEncodings,omitempty"`
+}
+
+// PositionEncodingKind tells what the character of a Position counts.
+type PositionEncodingKind string
+
+const (
+	// Bytes
+	PositionEncodingUTF8 PositionEncodingKind = "utf-8"
+	// UTF-16 code units, the default every client has to support
+	PositionEncodingUTF16 PositionEncodingKind = "utf-16"
+	// Unicode code points
+	PositionEncodingUTF32 PositionEncodingKind = "utf-32"
+)
+
 type ClientInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
@@ -30,26 +64,46 @@ type ServerInfo struct {
 	Version string `json:"version"`
 }
 
+// TextDocumentSyncKind tells the client how to send changes of documents.
+type TextDocumentSyncKind int
+
+const (
+	TextDocumentSyncNone TextDocumentSyncKind = iota
+	// The whole document is sent on every change
+	TextDocumentSyncFull
+	// Only the changed ranges are sent
+	TextDocumentSyncIncremental
+)
+
+// TextDocumentSyncOptions is the structured form of the textDocumentSync capability.
+type TextDocumentSyncOptions struct {
+	OpenClose bool                 `json:"openClose"`
+	Change    TextDocumentSyncKind `json:"change"`
+	// nil means we do not want didSave notifications
+	Save *SaveOptions `json:"save,omitempty"`
+}
+
+type SaveOptions struct {
+	IncludeText bool `json:"includeText"`
+}
+
 type ServerCapabilities struct {
-	TextDocumentSync   int            `json:"textDocumentSync"`
-	HoverProvider      bool           `json:"hoverProvider"`
-	DefinitionProvider bool           `json:"definitionProvider"`
-	CompletionProvider map[string]any `json:"completionProvider"`
+	TextDocumentSync   *TextDocumentSyncOptions `json:"textDocumentSync,omitempty"`
+	HoverProvider      bool                     `json:"hoverProvider"`
+	DefinitionProvider bool                     `json:"definitionProvider"`
+	ReferencesProvider bool                     `json:"referencesProvider"`
+	CompletionProvider map[string]any           `json:"completionProvider"`
+	PositionEncoding   PositionEncodingKind     `json:"positionEncoding,omitempty"`
 }
 
-func NewInitializeReponse(id int) InitializeResponse {
+func NewInitializeReponse(id ID, capabilities ServerCapabilities) InitializeResponse {
 	return InitializeResponse{
 		Response: Response{
-			RPC: "",
+			RPC: "2.0",
 			ID:  &id,
 		},
 		Result: InitializeResult{
-			Capabilities: ServerCapabilities{
-				TextDocumentSync:   1,
-				HoverProvider:      true,
-				DefinitionProvider: true,
-				CompletionProvider: map[string]any{},
-			},
+			Capabilities: capabilities,
 			ServerInfo: ServerInfo{
 				Name:    "scl_lsp",
 				Version: "0.0.0-alpha1",
@@ -57,3 +111,18 @@ func NewInitializeReponse(id int) InitializeResponse {
 		},
 	}
 }
+
+type ShutdownResponse struct {
+	Response
+	// Always null
+	Result *struct{} `json:"result"`
+}
+
+func NewShutdownResponse(id ID) ShutdownResponse {
+	return ShutdownResponse{
+		Response: Response{
+			RPC: "2.0",
+			ID:  &id,
+		},
+	}
+}
@@ -1,8 +1,10 @@
 package lsp
 
+import "fmt"
+
 type Request struct {
 	RPC    string `json:"jsonrpc"`
-	ID     int    `json:"id"`
+	ID     ID     `json:"id"`
 	Method string `json:"method"`
 
 	// we specify the params later
@@ -10,13 +12,97 @@ type Request struct {
 }
 
 type Response struct {
-	RPC string `json:"jsonrpc"`
-	ID  *int   `json:"id,omitempty"`
-
-	//
+	RPC   string         `json:"jsonrpc"`
+	ID    *ID            `json:"id"`
+	Error *ResponseError `json:"error,omitempty"`
 }
 
 type Notification struct {
 	RPC    string `json:"jsonrpc"`
 	Method string `json:"method"`
 }
+
+// $/cancelRequest
+type CancelRequestNotification struct {
+	Notification
+	Params CancelParams `json:"params"`
+}
+
+type CancelParams struct {
+	ID ID `json:"id"`
+}
+
+// TraceValue controls how much the server reports via $/logTrace.
+type TraceValue string
+
+const (
+	TraceOff      TraceValue = "off"
+	TraceMessages TraceValue = "messages"
+	TraceVerbose  TraceValue = "verbose"
+)
+
+// $/setTrace
+type SetTraceNotification struct {
+	Notification
+	Params SetTraceParams `json:"params"`
+}
+
+type SetTraceParams struct {
+	Value TraceValue `json:"value"`
+}
+
+// $/logTrace
+type LogTraceNotification struct {
+	Notification
+	Params LogTraceParams `json:"params"`
+}
+
+type LogTraceParams struct {
+	Message string `json:"message"`
+	Verbose string `json:"verbose,omitempty"`
+}
+
+// ErrorCode is the numeric code of a JSON-RPC error, including the LSP specific ones.
+type ErrorCode int
+
+const (
+	// Defined by JSON-RPC
+	ParseError     ErrorCode = -32700
+	InvalidRequest ErrorCode = -32600
+	MethodNotFound ErrorCode = -32601
+	InvalidParams  ErrorCode = -32602
+	InternalError  ErrorCode = -32603
+
+	// Defined by LSP
+	ServerNotInitialized ErrorCode = -32002
+	UnknownErrorCode     ErrorCode = -32001
+	RequestFailed        ErrorCode = -32803
+	ServerCancelled      ErrorCode = -32802
+	ContentModified      ErrorCode = -32801
+	RequestCancelled     ErrorCode = -32800
+)
+
+// ResponseError is the 'error' member of a response that could not be answered with a result.
+type ResponseError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Data    any       `json:"data,omitempty"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+func NewResponseError(code ErrorCode, format string, args ...any) *ResponseError {
+	return &ResponseError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewErrorResponse builds a response carrying only an error.
+// id is nil if the id of the request could not be determined (e.g. ParseError).
+func NewErrorResponse(id *ID, err *ResponseError) Response {
+	return Response{
+		RPC:   "2.0",
+		ID:    id,
+		Error: err,
+	}
+}
@@ -12,7 +12,7 @@ type TextDocumentIdentifier struct {
 }
 
 type VersionedTextDocumentIdentifies struct {
-	TextDocumentItem
+	TextDocumentIdentifier
 	Version int `json:"version"`
 }
 
@@ -47,8 +47,35 @@ type DidChangeTextDocumentParams struct {
 	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
 }
 
+// TextDocumentContentChangeEvent replaces Range with Text.
+// Without a Range Text is the whole new content of the document.
 type TextDocumentContentChangeEvent struct {
-	Text string `json:"text"`
+	Range *Range `json:"range,omitempty"`
+	// Deprecated by the spec, Range is all we need
+	RangeLength *int   `json:"rangeLength,omitempty"`
+	Text        string `json:"text"`
+}
+
+// TextDocumentDidClose
+type DidCloseTextDocumentNotification struct {
+	Notification
+	Params DidCloseTextDocumentParams `json:"params"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// TextDocumentDidSave
+type DidSaveTextDocumentNotification struct {
+	Notification
+	Params DidSaveTextDocumentParams `json:"params"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	// Only set if we asked for it with SaveOptions.IncludeText
+	Text *string `json:"text,omitempty"`
 }
 
 // TextDocument/Hover
@@ -95,6 +122,27 @@ type Range struct {
 	End   Position `json:"end"`
 }
 
+// TextDocument/References
+
+type ReferencesRequest struct {
+	Request
+	Params ReferenceParams `json:"params"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	Context ReferenceContext `json:"context"`
+}
+
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+type ReferencesResponse struct {
+	Response
+	Result []Location `json:"result"`
+}
+
 // TextDocument/Completion
 
 type CompletionRequest struct {
@@ -112,20 +160,30 @@ type CompletionResponse struct {
 }
 
 type CompletionItem struct {
-	Label            string `json:"label"`
-	Detail           string `json:"detail"`
-	Documentation    string `json:"documentation"`
-	InsertText       string `json:"insertText"`
-	InsertTextFormat int    `json:"insertTextFormat"`
+	Label            string              `json:"label"`
+	Detail           string              `json:"detail"`
+	Documentation    string              `json:"documentation"`
+	InsertText       string              `json:"insertText"`
+	InsertTextFormat int                 `json:"insertTextFormat"`
+	Tags             []CompletionItemTag `json:"tags,omitempty"`
 }
 
+type CompletionItemTag int
+
+const (
+	// Rendered struck through by most editors
+	CompletionItemTagDeprecated CompletionItemTag = 1
+)
+
 type PublishDiagnosticsNotificiation struct {
 	Notification
 	Params PublishDiagnosticsParams `json:"params"`
 }
 
 type PublishDiagnosticsParams struct {
-	URI         string       `json:"uri"`
+	URI string `json:"uri"`
+	// The version the diagnostics were computed for, nil if unknown
+	Version     *int         `json:"version,omitempty"`
 	Diagnostics []Diagnostic `json:"diagnostics"`
 }
 
@@ -0,0 +1,43 @@
+package lsp
+
+type MessageType int
+
+const (
+	MessageTypeError   MessageType = 1
+	MessageTypeWarning MessageType = 2
+	MessageTypeInfo    MessageType = 3
+	MessageTypeLog     MessageType = 4
+)
+
+// Window/ShowMessageRequest (server -> client)
+
+type ShowMessageRequestParams struct {
+	Type    MessageType         `json:"type"`
+	Message string              `json:"message"`
+	Actions []MessageActionItem `json:"actions,omitempty"`
+}
+
+type MessageActionItem struct {
+	Title string `json:"title"`
+}
+
+// Window/WorkDoneProgress/Create (server -> client)
+
+// ProgressToken is, just like a request id, an integer or a string.
+type ProgressToken = ID
+
+type WorkDoneProgressCreateParams struct {
+	Token ProgressToken `json:"token"`
+}
+
+// Window/LogMessage (server -> client)
+
+type LogMessageNotification struct {
+	Notification
+	Params LogMessageParams `json:"params"`
+}
+
+type LogMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"bufio"
+	"cmp"
 	"encoding/json"
 	"flag"
-	"io"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"sclls/internal"
 	"sclls/lsp"
@@ -16,153 +18,188 @@ import (
 )
 
 func main() {
-	logger := getLogger("/home/maxi/dev/scl_ls/log.txt")
-	needsPath := flag.String("needsPath", "/home/maxi/dev/scl_ls/needs.json", "The path to your needs.json")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:], os.Stdout))
+	}
+	needsPath := flag.String("needsPath", "needs.json", "The path or URL of your needs.json")
+	needsVersion := flag.String("needsVersion", "", "Version of the needs.json to use, the current_version in it if empty")
+	otherNeedsVersions := flag.String("otherNeedsVersions", "", "Versions (comma seperated, * for all) to also load needs from that do not exist in --needsVersion anymore")
+	var needsSources needsSourcesFlag
+	flag.Var(&needsSources, "needsSource", "Another needs.json to load, can be repeated: <path or URL>[,name=<name>][,prefix=<id prefix>][,docs=<docs folder>][,version=<version>][,external]")
+	needsCacheDir := flag.String("needsCacheDir", "", "Where needs.json files from URLs are kept for offline use, sclls in your cache folder if empty")
+	needsRefreshInterval := flag.Duration("needsRefreshInterval", 15*time.Minute, "How often needs.json files from URLs are checked for changes, 0 only checks them on startup")
 	enabled := flag.Bool("enable", true, "Disable the server.")
 	docsPath := flag.String("docsPath", "docs", "The path to your docs folder")
+	rstNeeds := flag.Bool("rstNeeds", false, "Also find the needs in the rst files of --docsPath, so needs are known before the docs are built again")
 	templateStrings := flag.String("templateStrings", "# req-Id:,# req-traceability:", "Template strings (comma seperated) to link source code linker")
-	//logger.Printf("Gotten following configs: %s, %s", needsPath, docsPath)
+	maxMessageSize := flag.Int("maxMessageSize", rpc.DefaultMaxMessageSize, "Biggest message (in bytes) the server accepts from the client")
+	stdio := flag.Bool("stdio", false, "Communicate over stdin/stdout (the default)")
+	listen := flag.String("listen", "", "Accept clients on a network address instead of stdio, e.g. tcp:127.0.0.1:9257")
+	socket := flag.String("socket", "", "Accept clients on a unix socket at this path instead of stdio")
+	disabledMethods := flag.String("disable", "", "LSP methods (comma seperated) the server should neither advertise nor handle, e.g. textDocument/completion")
+	logFile := flag.String("logFile", "", "File to write the logs to, stderr if empty")
+	logLevel := flag.String("logLevel", "info", "Minimum level that is logged: debug, info, warn or error")
+	logToClient := flag.Bool("logToClient", false, "Also show warnings and errors in the editor via window/logMessage")
+	diagnosticsDelay := flag.Duration("diagnosticsDelay", 200*time.Millisecond, "How long to wait for more changes before diagnostics are published")
+	hoverFields := flag.String("hoverFields", "", "Fields of the needs (comma seperated) to show on hover as well, any field of the needs.json can be used")
+	idCharacters := flag.String("idCharacters", internal.DefaultIDCharacters, "Regular expression matching one character of a need ID, IDs inside longer identifiers are ignored")
+	needsPollInterval := flag.Duration("needsPollInterval", 2*time.Second, "How often to check the needs.json for changes if the editor can not watch it, 0 disables reloading")
+	record := flag.String("record", "", "Write every message in both directions to this file, it can be replayed with 'scl_ls replay <file>'")
+	flag.Parse()
+	logger, err := newLogger(*logFile, *logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sclls: could not set up logging: %s\n", err.Error())
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
 	tmpltStrings := strings.Split(*templateStrings, ",")
-	logger.Println("Hey, sclls started")
+	logger.Info("Hey, sclls started")
 
 	srvConfig := internal.ServerConfig{
-		Enabled:          *enabled,
-		NeedsJsonPath:    *needsPath,
-		DocumentRootPath: *docsPath,
-		TemplateStrings:  tmpltStrings,
+		Enabled:              *enabled,
+		NeedsJsonPath:        *needsPath,
+		NeedsVersion:         *needsVersion,
+		OtherNeedsVersions:   splitList(*otherNeedsVersions),
+		NeedsSources:         needsSources,
+		NeedsCacheDir:        *needsCacheDir,
+		NeedsRefreshInterval: *needsRefreshInterval,
+		DocumentRootPath:     *docsPath,
+		RstNeeds:             *rstNeeds,
+		TemplateStrings:      tmpltStrings,
+		DisabledMethods:      splitList(*disabledMethods),
+		HoverFields:          splitList(*hoverFields),
+		IDCharacters:         *idCharacters,
+		DiagnosticsDelay:     *diagnosticsDelay,
 	}
-	state := internal.NewState(srvConfig, logger)
 	if !srvConfig.Enabled {
-		logger.Println("Server was disabled. Exciting")
+		logger.Info("Server was disabled. Exciting")
 		os.Exit(0)
 	}
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Split(rpc.Split)
-	writer := os.Stdout
-	for scanner.Scan() {
-		msg := scanner.Bytes()
-		method, content, err := rpc.DecodeMsg(msg)
+	if *listen != "" && *socket != "" || *stdio && (*listen != "" || *socket != "") {
+		logger.Error("Only one of --stdio, --listen and --socket can be used")
+		os.Exit(2)
+	}
+	if _, err := internal.NewNeedDetector(srvConfig.IDCharacters, srvConfig.TemplateStrings); err != nil {
+		logger.Error("Invalid --idCharacters", "err", err)
+		os.Exit(2)
+	}
+	if *record != "" && (*listen != "" || *socket != "") {
+		logger.Error("--record only works with --stdio, a recording can only hold one session")
+		os.Exit(2)
+	}
+	srv := &server{
+		routes:         newRegistry(srvConfig.DisabledMethods),
+		config:         srvConfig,
+		needs:          internal.LoadNeedsIndex(srvConfig, logger),
+		logger:         logger,
+		logToClient:    *logToClient,
+		maxMessageSize: *maxMessageSize,
+	}
+	if *needsPollInterval > 0 {
+		srv.watcher = newNeedsWatcher(srv.needs, srvConfig, logger, *needsPollInterval)
+	}
+	if slices.ContainsFunc(srvConfig.Sources(), func(source *internal.NeedsSource) bool { return source.URL != "" }) {
+		go refreshRemoteNeeds(srv.needs, srvConfig, logger, srvConfig.NeedsRefreshInterval)
+	}
+	if *record != "" {
+		recordFile, err := os.Create(*record)
 		if err != nil {
-			logger.Printf("got an error: %s", err.Error())
+			logger.Error("Could not create recording", "err", err)
+			os.Exit(2)
 		}
-		handleMessage(logger, writer, &state, method, content)
+		// Every message is written right away, so it is fine that os.Exit never closes the file
+		srv.recorder = rpc.NewRecorder(recordFile)
+	}
+	switch {
+	case *listen != "":
+		network, address, err := parseListenAddress(*listen)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(2)
+		}
+		logger.Error("Stopped listening", "err", srv.listen(network, address))
+		os.Exit(1)
+	case *socket != "":
+		logger.Error("Stopped listening", "err", srv.listen("unix", *socket))
+		os.Exit(1)
+	default:
+		os.Exit(srv.serve(os.Stdin, os.Stdout))
 	}
 }
 
-func handleMessage(logger *log.Logger, writer io.Writer, state *internal.State, method string, contents []byte) {
-	logger.Printf("Revieced msg with method: %s", method)
-	//logger.Printf("Revieced msg contents: %s", contents)
+// splitList splits a comma seperated flag value, an empty value is an empty list.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return parts
+}
 
-	switch method {
-	case "initialize":
-		var request lsp.InitializeRequest
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("could not parse stuff: %s", err.Error())
-			return
-		}
-		logger.Printf("Connected to: %s %s", request.Params.ClientInfo.Name, request.Params.ClientInfo.Version)
-		// let's reply here. How?
-		msg := lsp.NewInitializeReponse(request.ID)
-		writeResponse(writer, msg)
+// needsSourcesFlag collects every --needsSource.
+type needsSourcesFlag []internal.NeedsSource
 
-		logger.Printf("Send the reply: %v", msg)
-	case "textDocument/didOpen":
-		var request lsp.DidOpenTextDocumentNotification
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("could not parse stuff: %s", err.Error())
-		}
-		logger.Printf("Opened : %s", request.Params.TextDocument.URI)
-		// let's reply here. How?
-		logger.Printf("Text inside the File: %s", request.Params.TextDocument.Text)
-		diagnostics := state.OpenDocument(request.Params.TextDocument.URI, request.Params.TextDocument.Text)
-		writeResponse(writer, lsp.PublishDiagnosticsNotificiation{
-			Notification: lsp.Notification{
-				RPC:    "2.0",
-				Method: "textDocument/publishDiagnostics",
-			},
-			Params: lsp.PublishDiagnosticsParams{
-				URI:         request.Params.TextDocument.URI,
-				Diagnostics: diagnostics,
-			},
-		})
-	case "textDocument/didChange":
-		var request lsp.TextDocumentDidChangeNotification
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("could not parse stuff. didChange. Err: %s", err.Error())
-			return
-		}
-		logger.Printf("Opened : %s", request.Params.TextDocument.URI)
-		for _, change := range request.Params.ContentChanges {
-			diagnostics := state.UpdateDocument(request.Params.TextDocument.URI, change.Text)
-			writeResponse(writer, lsp.PublishDiagnosticsNotificiation{
-				Notification: lsp.Notification{
-					RPC:    "2.0",
-					Method: "textDocument/publishDiagnostics",
-				},
-				Params: lsp.PublishDiagnosticsParams{
-					URI:         request.Params.TextDocument.URI,
-					Diagnostics: diagnostics,
-				},
-			})
-		}
-	case "textDocument/hover":
-		//Hover msg ('K')
-		var request lsp.HoverRequest
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("Hover: could not parse request: %s", err.Error())
-			return
-		}
-		logger.Printf("Hover was requested")
-		var responseStr string
-		foundNeed, err := state.FindNeedsInRequestedPosition(request.Params.TextDocument.URI, request.Params.Position)
-		if err != nil {
-			responseStr = err.Error()
-		} else {
-			responseStr = foundNeed.GenerateHoverInfo()
-		}
-		response := lsp.HoverResponse{
-			Response: lsp.Response{
-				RPC: "2.0",
-				ID:  &request.ID,
-			},
-			Result: lsp.HoverResult{
-				Contents: responseStr,
-			},
-		}
-		writeResponse(writer, response)
-	case "textDocument/definition":
-		//Def request ('gd')
-		var request lsp.DefinitionRequest
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("Definition: could not parse stuff in request: %s", err.Error())
-		}
-		// TODO: Delete these logger stmts
-		logger.Printf("Go to definition was requested")
-		logger.Printf("ID: %d, URI: %s, Pos: %v", request.ID, request.Params.TextDocument.URI, request.Params.Position)
+func (f *needsSourcesFlag) String() string {
+	var paths []string
+	for _, source := range *f {
+		paths = append(paths, cmp.Or(source.Path, source.URL))
+	}
+	return strings.Join(paths, " ")
+}
 
-		msg := state.GoToDefinition(request.ID, request.Params.TextDocument.URI, request.Params.Position)
-		writeResponse(writer, msg)
-	case "textDocument/completion":
-		var request lsp.CompletionRequest
-		if err := json.Unmarshal(contents, &request); err != nil {
-			logger.Printf("could not parse stuff in textDocument Completion request: %s", err.Error())
-		}
+func (f *needsSourcesFlag) Set(value string) error {
+	source, err := parseNeedsSource(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, source)
+	return nil
+}
 
-		msg := state.TextDocumentCompletion(request.ID, request.Params.TextDocument.URI, request.Params.Position)
-		writeResponse(writer, msg)
+// parseNeedsSource parses "<path>,key=value,..." as described for --needsSource.
+func parseNeedsSource(value string) (internal.NeedsSource, error) {
+	parts := splitList(value)
+	if len(parts) == 0 || parts[0] == "" {
+		return internal.NeedsSource{}, fmt.Errorf("needs source %q has no path", value)
+	}
+	source := internal.NeedsSource{Path: parts[0]}
+	if internal.IsURL(source.Path) {
+		source = internal.NeedsSource{URL: source.Path}
 	}
+	for _, part := range parts[1:] {
+		key, option, _ := strings.Cut(part, "=")
+		switch key {
+		case "name":
+			source.Name = option
+		case "prefix":
+			source.IDPrefix = option
+		case "docs":
+			source.DocsRoot = option
+		case "version":
+			source.Version = option
+		case "external":
+			source.External = true
+		default:
+			return internal.NeedsSource{}, fmt.Errorf("unknown option %q of needs source %q", key, parts[0])
+		}
+	}
+	return source, nil
 }
 
-func writeResponse(writer io.Writer, msg any) {
-	reply := rpc.EncodeMsg(msg)
-	writer.Write([]byte(reply))
+// requestID extracts the id of a request, nil if it has none or it is malformed.
+func requestID(baseMsg rpc.BaseMessage) *lsp.ID {
+	var id lsp.ID
+	if err := json.Unmarshal(baseMsg.ID, &id); err != nil {
+		return nil
+	}
+	return &id
 }
 
-func getLogger(filename string) *log.Logger {
-	logfile, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-	if err != nil {
-		// TODO: better error here
-		panic("not a good file")
+func writeResponse(writer *rpc.Writer, msg any) {
+	if err := writer.Write(msg); err != nil {
+		slog.Error("could not write message", "err", err)
 	}
-	return log.New(logfile, "[sclls]", log.Ldate|log.Ltime|log.Lshortfile)
 }
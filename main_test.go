@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+func newTestDispatcher(out io.Writer) *dispatcher {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	state := internal.NewState(internal.ServerConfig{TemplateStrings: []string{"# req-Id: "}}, logger)
+	return newDispatcher(newRegistry(nil), logger, rpc.NewWriter(out), state)
+}
+
+func TestDispatchLifecycle(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+
+	d.dispatch("textDocument/hover", []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`))
+	d.wait()
+	if !strings.Contains(out.String(), `"code":-32002`) {
+		t.Fatalf("Expected ServerNotInitialized before initialize, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":"init","method":"initialize","params":{}}`))
+	if !strings.Contains(out.String(), `"id":"init"`) || !strings.Contains(out.String(), `"capabilities"`) {
+		t.Fatalf("Expected initialize result echoing the string id, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("textDocument/unknown", []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/unknown"}`))
+	d.wait()
+	if !strings.Contains(out.String(), `"code":-32601`) {
+		t.Errorf("Expected MethodNotFound, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("$/unknown", []byte(`{"jsonrpc":"2.0","method":"$/unknown"}`))
+	if out.Len() != 0 {
+		t.Errorf("Expected unknown notifications to be ignored, got %s", out.String())
+	}
+
+	d.dispatch("shutdown", []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`))
+	if !strings.Contains(out.String(), `"result":null`) {
+		t.Errorf("Expected null result for shutdown, got %s", out.String())
+	}
+	d.dispatch("exit", []byte(`{"jsonrpc":"2.0","method":"exit"}`))
+	if d.state.Status() != internal.StatusExited || d.state.ExitCode() != 0 {
+		t.Errorf("Expected orderly exit, got status %s with code %d", d.state.Status(), d.state.ExitCode())
+	}
+}
+
+func TestDispatchInvalidParams(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	out.Reset()
+
+	d.dispatch("textDocument/hover", []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"position":"nope"}}`))
+	d.wait()
+	if !strings.Contains(out.String(), `"code":-32602`) {
+		t.Errorf("Expected InvalidParams, got %s", out.String())
+	}
+}
+
+func TestCancelUnknownRequest(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	// Must not panic or answer anything
+	d.dispatch("$/cancelRequest", []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":99}}`))
+	if out.Len() != 0 {
+		t.Errorf("Expected no reply to $/cancelRequest, got %s", out.String())
+	}
+	if len(d.inFlight) != 0 {
+		t.Errorf("Expected no requests in flight, got %d", len(d.inFlight))
+	}
+}
+
+func TestShutdownWaitsForRunningRequests(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","languageId":"python","version":1,"text":"# req-Id: REQ"}}}`))
+
+	d.dispatch("textDocument/completion", []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///a.py"},"position":{"line":0,"character":13}}}`))
+	d.dispatch("shutdown", []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`))
+	if strings.Contains(out.String(), "shutting down") {
+		t.Errorf("Expected the completion sent before shutdown to be handled, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"id":2`) {
+		t.Errorf("Expected the completion to be answered before shutdown, got %s", out.String())
+	}
+}
+
+func TestDispatchRejectsDuplicateIDs(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	out.Reset()
+
+	// Pretend request 2 is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.inFlight[lsp.NewIntID(2)] = cancel
+	d.dispatch("textDocument/hover", []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{}}`))
+	d.wait()
+	if !strings.Contains(out.String(), `"code":-32600`) || !strings.Contains(out.String(), `"id":2`) {
+		t.Errorf("Expected InvalidRequest for the reused id, got %s", out.String())
+	}
+	d.dispatch("$/cancelRequest", []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":2}}`))
+	if ctx.Err() == nil {
+		t.Error("Expected $/cancelRequest to still reach the running request")
+	}
+}
+
+func TestDispatchDeliversResponses(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	d := newTestDispatcher(pw)
+	defer d.close()
+
+	type result struct {
+		picked *lsp.MessageActionItem
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		picked, err := d.client.showMessageRequest(context.Background(), lsp.ShowMessageRequestParams{
+			Type:    lsp.MessageTypeInfo,
+			Message: "Reload needs.json?",
+			Actions: []lsp.MessageActionItem{{Title: "Yes"}, {Title: "No"}},
+		})
+		done <- result{picked, err}
+	}()
+
+	content, err := rpc.NewReader(pr).ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseMsg, err := rpc.DecodeBaseMessage(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseMsg.Method != "window/showMessageRequest" || baseMsg.IsNotification() {
+		t.Fatalf("Expected a window/showMessageRequest request, got %s", content)
+	}
+	d.dispatch("", []byte(`{"jsonrpc":"2.0","id":`+string(baseMsg.ID)+`,"result":{"title":"Yes"}}`))
+
+	got := <-done
+	if got.err != nil {
+		t.Fatalf("showMessageRequest() unexpected error = %v", got.err)
+	}
+	if got.picked == nil || got.picked.Title != "Yes" {
+		t.Errorf("showMessageRequest() = %v, want Yes", got.picked)
+	}
+}
+
+func TestInitializeNegotiatesPositionEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		expected string
+	}{
+		{"client prefers utf-8", `{"capabilities":{"general":{"positionEncodings":["utf-8","utf-16"]}}}`, `"positionEncoding":"utf-8"`},
+		{"no encodings offered", `{"capabilities":{}}`, `"positionEncoding":"utf-16"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			d := newTestDispatcher(&out)
+			d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":`+tt.params+`}`))
+			if !strings.Contains(out.String(), tt.expected) {
+				t.Errorf("Expected %s in the initialize result, got %s", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestDidClosePublishesEmptyDiagnostics(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","text":"# req-Id: MISSING"}}}`))
+	d.diagnostics.flush()
+	out.Reset()
+
+	d.dispatch("textDocument/didClose", []byte(`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"file:///a.py"}}}`))
+	if !strings.Contains(out.String(), `"diagnostics":[]`) {
+		t.Errorf("Expected empty diagnostics after didClose, got %s", out.String())
+	}
+	if d.state.CloseDocument("file:///a.py") {
+		t.Error("Expected the document to be gone after didClose")
+	}
+}
+
+func TestDidChangeVersions(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","version":1,"text":"# req-Id: MISSING"}}}`))
+	d.diagnostics.flush()
+	if !strings.Contains(out.String(), `"version":1`) {
+		t.Errorf("Expected diagnostics for version 1, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("textDocument/didChange", []byte(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.py","version":2},"contentChanges":[{"text":"# req-Id: OTHER"}]}}`))
+	d.diagnostics.flush()
+	if !strings.Contains(out.String(), `"version":2`) {
+		t.Errorf("Expected diagnostics for version 2, got %s", out.String())
+	}
+	out.Reset()
+
+	d.dispatch("textDocument/didChange", []byte(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.py","version":2},"contentChanges":[{"text":"# req-Id: STALE"}]}}`))
+	d.diagnostics.flush()
+	if out.Len() != 0 {
+		t.Errorf("Expected an out of order change to be dropped, got %s", out.String())
+	}
+}
+
+func TestDiagnosticsAreDebounced(t *testing.T) {
+	var out bytes.Buffer
+	d := newTestDispatcher(&out)
+	d.diagnostics.delay = time.Hour
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	out.Reset()
+
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","version":1,"text":"# req-Id: ONE"}}}`))
+	for version, text := range []string{"TWO", "THREE", "REQ_001"} {
+		d.dispatch("textDocument/didChange", []byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.py","version":%d},"contentChanges":[{"range":{"start":{"line":0,"character":10},"end":{"line":0,"character":20}},"text":"%s"}]}}`, version+2, text)))
+	}
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///b.py","version":1,"text":"# req-Id: MISSING"}}}`))
+	if out.Len() != 0 {
+		t.Fatalf("Expected nothing to be published before the delay, got %s", out.String())
+	}
+
+	d.diagnostics.flush()
+	published := strings.Count(out.String(), "textDocument/publishDiagnostics")
+	if published != 2 {
+		t.Fatalf("Expected one publish per document, got %d: %s", published, out.String())
+	}
+	if !strings.Contains(out.String(), `"uri":"file:///a.py","version":4,`) || !strings.Contains(out.String(), "'REQ_001' not found") {
+		t.Errorf("Expected only the latest version of a.py to be published, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"uri":"file:///b.py","version":1,"diagnostics":[{`) {
+		t.Errorf("Expected the diagnostics of b.py, got %s", out.String())
+	}
+}
+
+func TestParseNeedsSource(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    internal.NeedsSource
+		wantErr bool
+	}{
+		{value: "score/needs.json", want: internal.NeedsSource{Path: "score/needs.json"}},
+		{
+			value: "score/needs.json, name=score, prefix=SCORE_, docs=score/docs, version=1.0, external",
+			want:  internal.NeedsSource{Path: "score/needs.json", Name: "score", IDPrefix: "SCORE_", DocsRoot: "score/docs", Version: "1.0", External: true},
+		},
+		{
+			value: "https://example.com/docs/needs.json,name=docs",
+			want:  internal.NeedsSource{URL: "https://example.com/docs/needs.json", Name: "docs"},
+		},
+		{value: "", wantErr: true},
+		{value: "score/needs.json,prefx=SCORE_", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseNeedsSource(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNeedsSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNeedsSource() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
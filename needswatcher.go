@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// needsWatcher polls the needs.json files and reloads the needs index when one of them changed.
+// It is the fallback for clients that can not watch files for us, so it only runs
+// while at least one session uses it.
+type needsWatcher struct {
+	needs    *internal.NeedsIndex
+	config   internal.ServerConfig
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu    sync.Mutex
+	users int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newNeedsWatcher(needs *internal.NeedsIndex, config internal.ServerConfig, logger *slog.Logger, interval time.Duration) *needsWatcher {
+	return &needsWatcher{needs: needs, config: config, logger: logger, interval: interval}
+}
+
+// acquire starts polling if nobody else needs it yet. Polling stops once every user called release.
+func (w *needsWatcher) acquire() (release func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.users++
+	if w.users == 1 {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.poll(w.stop, w.done, statSources(w.config))
+	}
+	var once sync.Once
+	return func() { once.Do(w.release) }
+}
+
+func (w *needsWatcher) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.users--
+	if w.users == 0 {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+func (w *needsWatcher) poll(stop <-chan struct{}, done chan<- struct{}, last []fileStamp) {
+	defer close(done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current := statSources(w.config)
+		written := false
+		for i := range current {
+			// A file that is gone is being written again by sphinx-build or not there yet,
+			// the change that brings it back reloads it
+			if current[i] != last[i] && current[i].exists {
+				written = true
+			}
+		}
+		last = current
+		if written {
+			reloadNeeds(w.needs, w.config, w.logger)
+		}
+	}
+}
+
+// fileStamp changes whenever the file is written.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// statSources returns the stamps of every local needs.json of the config, in the order of ServerConfig.Sources.
+// Those from URLs are checked by refreshRemoteNeeds.
+func statSources(config internal.ServerConfig) []fileStamp {
+	var stamps []fileStamp
+	for _, source := range config.Sources() {
+		if source.URL == "" {
+			stamps = append(stamps, statFile(source.Path))
+		}
+	}
+	return stamps
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// reloadNeeds swaps in the current needs.json files, or keeps the old needs if one can not be read.
+func reloadNeeds(needs *internal.NeedsIndex, config internal.ServerConfig, logger *slog.Logger) {
+	if err := needs.Reload(config, logger); err != nil {
+		// Only the needs of the sources that failed are the old ones, unless all failed
+		logger.Warn("Keeping the previous needs of a needs.json that could not be reloaded", "err", err)
+	}
+	logger.Info("Reloaded the needs.json", "sources", len(config.Sources()), "needs", len(needs.Needs()))
+}
+
+// needsReloadDelay coalesces the events of one docs build or of saving many files into one reload.
+const needsReloadDelay = 100 * time.Millisecond
+
+// needsReloader reloads the needs of a session off the reading goroutine, for big docs that takes a while.
+// Changes that come in while it waits are reloaded together. If only rst files changed
+// the needs.json files are not read again.
+type needsReloader struct {
+	s *session
+	// Taken from the state once, shutdown drops them there
+	needs  *internal.NeedsIndex
+	config internal.ServerConfig
+
+	mu    sync.Mutex
+	timer *time.Timer
+	// A needs.json changed, not only rst files
+	needsJson bool
+	wg        sync.WaitGroup
+	// After close nothing gets scheduled anymore
+	closed bool
+}
+
+func newNeedsReloader(s *session) *needsReloader {
+	return &needsReloader{s: s, needs: s.state.Needs, config: s.state.ServerConfig}
+}
+
+// schedule reloads the needs after needsReloadDelay, unless it is scheduled again before.
+func (r *needsReloader) schedule(needsJson bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.needsJson = r.needsJson || needsJson
+	r.stopLocked()
+	r.wg.Add(1)
+	r.timer = time.AfterFunc(needsReloadDelay, func() {
+		defer r.wg.Done()
+		r.run()
+	})
+}
+
+func (r *needsReloader) stopLocked() {
+	if r.timer != nil && r.timer.Stop() {
+		// Never started, so it will not mark itself as done
+		r.wg.Done()
+	}
+	r.timer = nil
+}
+
+func (r *needsReloader) run() {
+	r.mu.Lock()
+	needsJson := r.needsJson
+	r.needsJson = false
+	r.mu.Unlock()
+	if needsJson {
+		reloadNeeds(r.needs, r.config, r.s.logger)
+		return
+	}
+	r.needs.ReloadRst(r.config, r.s.logger)
+	r.s.logger.Debug("Reloaded the needs of the rst files", "needs", len(r.needs.Needs()))
+}
+
+// flush runs a pending reload right away and waits until it is done.
+func (r *needsReloader) flush() {
+	r.mu.Lock()
+	if r.timer != nil && r.timer.Stop() {
+		r.timer = nil
+		go func() {
+			defer r.wg.Done()
+			r.run()
+		}()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// close drops a pending reload and waits for a running one.
+func (r *needsReloader) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.stopLocked()
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// refreshRemoteNeeds checks the needs.json files from URLs right away, the server started with the cached ones,
+// and then every interval. It reloads the needs if one of them changed and runs as long as the server.
+// With an interval of 0 they are only checked once.
+func refreshRemoteNeeds(needs *internal.NeedsIndex, config internal.ServerConfig, logger *slog.Logger, interval time.Duration) {
+	refresh := func() {
+		changed, err := internal.FetchRemoteSources(config, logger)
+		if err != nil {
+			logger.Warn("Could not refresh the needs.json", "err", err)
+		}
+		if changed {
+			reloadNeeds(needs, config, logger)
+		}
+	}
+	refresh()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		refresh()
+	}
+}
+
+// watchNeedsJson makes sure the needs are reloaded after the needs.json was built again.
+// Clients that can watch files are asked to tell us about changes, otherwise the file is polled.
+func (s *session) watchNeedsJson() {
+	if !s.clientWatchesFiles {
+		s.pollNeedsJson()
+		return
+	}
+	var watchers []lsp.FileSystemWatcher
+	for _, source := range s.state.Sources() {
+		if source.URL != "" {
+			continue
+		}
+		path, err := filepath.Abs(source.Path)
+		if err != nil {
+			s.logger.Warn("Not watching the needs.json", "path", source.Path, "err", err)
+			continue
+		}
+		watchers = append(watchers, lsp.FileSystemWatcher{GlobPattern: filepath.ToSlash(path)})
+	}
+	if s.state.RstNeeds {
+		if docsRoot, err := filepath.Abs(s.state.DocumentRootPath); err == nil {
+			watchers = append(watchers, lsp.FileSystemWatcher{GlobPattern: filepath.ToSlash(docsRoot) + "/**/*.rst"})
+		}
+	}
+	if len(watchers) == 0 {
+		return
+	}
+	registration := lsp.Registration{
+		ID:     "needs.json",
+		Method: "workspace/didChangeWatchedFiles",
+		RegisterOptions: lsp.DidChangeWatchedFilesRegistrationOptions{
+			Watchers: watchers,
+		},
+	}
+	// The response arrives on the reading goroutine, we may not wait for it here
+	go func() {
+		err := s.client.registerCapability(context.Background(), registration)
+		if err != nil && !errors.Is(err, rpc.ErrClosed) {
+			s.logger.Warn("Client does not watch the needs.json, polling it instead", "err", err)
+			s.pollNeedsJson()
+		}
+	}()
+}
+
+// pollNeedsJson uses the watcher of the server for this session, unless polling is off or the session is closed.
+func (s *session) pollNeedsJson() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watcher == nil || s.watchingStopped || s.stopWatching != nil {
+		return
+	}
+	s.stopWatching = s.watcher.acquire()
+}
+
+// stopPolling releases the watcher, it is not started for this session afterwards.
+func (s *session) stopPolling() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	s.watchingStopped = true
+	if s.stopWatching != nil {
+		s.stopWatching()
+	}
+}
+
+// isNeedsJson reports if uri points to one of the needs.json files we loaded.
+func (s *session) isNeedsJson(uri string) bool {
+	path, err := internal.GetPathFromURI(uri)
+	if err != nil {
+		return false
+	}
+	for _, source := range s.state.Sources() {
+		if source.URL != "" {
+			continue
+		}
+		if needsPath, err := filepath.Abs(source.Path); err == nil && filepath.Clean(path) == needsPath {
+			return true
+		}
+	}
+	return false
+}
+
+// isRstSource reports if uri is an rst file the needs are read from, see ServerConfig.RstNeeds.
+func (s *session) isRstSource(uri string) bool {
+	if !s.state.RstNeeds {
+		return false
+	}
+	path, err := internal.GetPathFromURI(uri)
+	if err != nil || filepath.Ext(path) != ".rst" {
+		return false
+	}
+	docsRoot, err := filepath.Abs(s.state.DocumentRootPath)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(docsRoot, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// needsChanged runs after the needs index was updated, the needs and diagnostics
+// of every open document have to be found again.
+func (s *session) needsChanged() {
+	for _, uri := range s.state.RefreshNeeds() {
+		s.diagnostics.schedule(uri)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sclls/internal"
+	"sclls/rpc"
+)
+
+func writeNeeds(t *testing.T, path string, ids ...string) {
+	t.Helper()
+	var needs []string
+	for _, id := range ids {
+		needs = append(needs, fmt.Sprintf(`"%s": {"id": "%s"}`, id, id))
+	}
+	content := `{"current_version": "1", "versions": {"1": {"needs": {` + strings.Join(needs, ",") + `}}}}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNeedsWatcherPolls(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	config := internal.ServerConfig{
+		NeedsJsonPath: filepath.Join(dir, "needs.json"),
+		// Not downloaded yet, our own needs.json has to be reloaded anyway
+		NeedsSources: []internal.NeedsSource{{Path: filepath.Join(dir, "upstream.json")}},
+	}
+	writeNeeds(t, config.NeedsJsonPath, "REQ_1")
+	index := internal.LoadNeedsIndex(config, logger)
+	updated := make(chan struct{}, 1)
+	index.OnUpdate(func() { updated <- struct{}{} })
+
+	var out bytes.Buffer
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(&out), internal.NewSession(config, index, logger))
+	d.watcher = newNeedsWatcher(index, config, logger, 5*time.Millisecond)
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	d.dispatch("initialized", []byte(`{"jsonrpc":"2.0","method":"initialized","params":{}}`))
+	if d.watcher.users != 1 {
+		t.Fatalf("Expected a client that can not watch files to poll the needs.json")
+	}
+
+	writeNeeds(t, config.NeedsJsonPath, "REQ_1", "REQ_2")
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The changed needs.json was not reloaded")
+	}
+	if len(index.Needs()) != 2 {
+		t.Errorf("Expected 2 needs after the reload, got %d", len(index.Needs()))
+	}
+
+	d.close()
+	if d.watcher.users != 0 {
+		t.Errorf("Expected polling to stop with the last session")
+	}
+}
+
+func TestDidChangeWatchedFilesReloadsNeeds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "needs.json")
+	writeNeeds(t, path, "REQ_1")
+	config := internal.ServerConfig{NeedsJsonPath: path, TemplateStrings: []string{"# req-Id: "}}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(pw), internal.NewState(config, logger))
+	defer d.close()
+	messages := make(chan string, 16)
+	go func() {
+		reader := rpc.NewReader(pr)
+		for {
+			content, err := reader.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(content)
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case msg := <-messages:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a message from the server")
+			return ""
+		}
+	}
+
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"workspace":{"didChangeWatchedFiles":{"dynamicRegistration":true}}}}}`))
+	next()
+	d.dispatch("initialized", []byte(`{"jsonrpc":"2.0","method":"initialized","params":{}}`))
+	registration := next()
+	if !strings.Contains(registration, `"method":"client/registerCapability"`) || !strings.Contains(registration, filepath.ToSlash(path)) {
+		t.Fatalf("Expected the needs.json to be registered for watching, got %s", registration)
+	}
+	baseMsg, err := rpc.DecodeBaseMessage([]byte(registration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch("", []byte(`{"jsonrpc":"2.0","id":`+string(baseMsg.ID)+`,"result":null}`))
+
+	d.dispatch("textDocument/didOpen", []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","version":1,"text":"# req-Id: REQ_2"}}}`))
+	d.diagnostics.flush()
+	if msg := next(); !strings.Contains(msg, "'REQ_2' not found") {
+		t.Fatalf("Expected REQ_2 to be unknown, got %s", msg)
+	}
+
+	// A broken file keeps the needs we have
+	if err := os.WriteFile(path, []byte(`{"versions": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file://" + filepath.ToSlash(path)
+	d.dispatch("workspace/didChangeWatchedFiles", []byte(`{"jsonrpc":"2.0","method":"workspace/didChangeWatchedFiles","params":{"changes":[{"uri":"`+uri+`","type":2}]}}`))
+	d.reloader.flush()
+	if len(d.state.Needs.Needs()) != 1 {
+		t.Fatalf("Expected the previous needs to stay after a failed reload, got %v", d.state.Needs.Needs())
+	}
+
+	writeNeeds(t, path, "REQ_1", "REQ_2")
+	d.dispatch("workspace/didChangeWatchedFiles", []byte(`{"jsonrpc":"2.0","method":"workspace/didChangeWatchedFiles","params":{"changes":[{"uri":"file:///other.json","type":2},{"uri":"`+uri+`","type":2}]}}`))
+	d.reloader.flush()
+	d.diagnostics.flush()
+	if msg := next(); !strings.Contains(msg, `"uri":"file:///a.py","version":1,"diagnostics":[]`) {
+		t.Errorf("Expected the diagnostics of a.py to be republished without errors, got %s", msg)
+	}
+}
+
+func TestSavingRstReloadsNeeds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	config := internal.ServerConfig{NeedsJsonPath: filepath.Join(dir, "needs.json"), DocumentRootPath: dir, RstNeeds: true}
+	writeNeeds(t, config.NeedsJsonPath, "REQ_1")
+	index := internal.LoadNeedsIndex(config, logger)
+	var out bytes.Buffer
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(&out), internal.NewSession(config, index, logger))
+	defer d.close()
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+
+	rst := filepath.Join(dir, "index.rst")
+	if err := os.WriteFile(rst, []byte(".. req:: New\n   :id: REQ_NEW\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Moving the needs.json away must not matter, only the rst files are read again
+	if err := os.Rename(config.NeedsJsonPath, config.NeedsJsonPath+".old"); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file://" + filepath.ToSlash(rst)
+	d.dispatch("textDocument/didSave", []byte(`{"jsonrpc":"2.0","method":"textDocument/didSave","params":{"textDocument":{"uri":"`+uri+`"}}}`))
+	if _, ok := index.Needs()["REQ_NEW"]; ok {
+		t.Error("Expected the reload to run off the reading goroutine")
+	}
+	d.reloader.flush()
+	needs := index.Needs()
+	if _, ok := needs["REQ_NEW"]; !ok || len(needs) != 2 {
+		t.Errorf("Expected REQ_1 and REQ_NEW after saving the rst file, got %v", needs)
+	}
+}
+
+func TestShutdownDropsPendingReload(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	config := internal.ServerConfig{NeedsJsonPath: filepath.Join(dir, "needs.json"), DocumentRootPath: dir, RstNeeds: true}
+	writeNeeds(t, config.NeedsJsonPath, "REQ_1")
+	index := internal.LoadNeedsIndex(config, logger)
+	var out bytes.Buffer
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(&out), internal.NewSession(config, index, logger))
+	defer d.close()
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+
+	rst := filepath.Join(dir, "index.rst")
+	if err := os.WriteFile(rst, []byte(".. req:: New\n   :id: REQ_NEW\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.dispatch("textDocument/didSave", []byte(`{"jsonrpc":"2.0","method":"textDocument/didSave","params":{"textDocument":{"uri":"file://`+filepath.ToSlash(rst)+`"}}}`))
+	d.dispatch("shutdown", []byte(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`))
+	d.wait()
+	// Would have run by now
+	time.Sleep(2 * needsReloadDelay)
+	if _, ok := index.Needs()["REQ_NEW"]; ok {
+		t.Error("Expected the pending reload to be dropped on shutdown")
+	}
+}
+
+func TestRejectedRegistrationPollsNeeds(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := internal.ServerConfig{NeedsJsonPath: filepath.Join(t.TempDir(), "needs.json")}
+	writeNeeds(t, config.NeedsJsonPath, "REQ_1")
+	index := internal.LoadNeedsIndex(config, logger)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	d := newDispatcher(newRegistry(nil), logger, rpc.NewWriter(pw), internal.NewSession(config, index, logger))
+	d.watcher = newNeedsWatcher(index, config, logger, time.Hour)
+	registrations := make(chan rpc.BaseMessage, 1)
+	go func() {
+		reader := rpc.NewReader(pr)
+		for {
+			content, err := reader.ReadMessage()
+			if err != nil {
+				return
+			}
+			if baseMsg, err := rpc.DecodeBaseMessage(content); err == nil && baseMsg.Method == "client/registerCapability" {
+				registrations <- baseMsg
+			}
+		}
+	}()
+	users := func() int {
+		d.watcher.mu.Lock()
+		defer d.watcher.mu.Unlock()
+		return d.watcher.users
+	}
+
+	d.dispatch("initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"workspace":{"didChangeWatchedFiles":{"dynamicRegistration":true}}}}}`))
+	d.dispatch("initialized", []byte(`{"jsonrpc":"2.0","method":"initialized","params":{}}`))
+	var registration rpc.BaseMessage
+	select {
+	case registration = <-registrations:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the needs.json to be registered for watching")
+	}
+	if users() != 0 {
+		t.Fatal("Expected no polling while the client may watch the needs.json")
+	}
+	d.dispatch("", []byte(`{"jsonrpc":"2.0","id":`+string(registration.ID)+`,"error":{"code":-32601,"message":"not supported"}}`))
+	for start := time.Now(); users() != 1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Expected the needs.json to be polled after the client rejected watching it")
+		}
+	}
+
+	d.close()
+	if users() != 0 {
+		t.Errorf("Expected polling to stop with the session")
+	}
+}
+
+func TestRefreshRemoteNeedsOnStartup(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream.json")
+	writeNeeds(t, upstream, "REQ_1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := os.ReadFile(upstream)
+		w.Write(content)
+	}))
+	defer server.Close()
+	config := internal.ServerConfig{NeedsCacheDir: filepath.Join(dir, "cache"), NeedsSources: []internal.NeedsSource{{URL: server.URL}}}
+	internal.LoadNeedsIndex(config, logger)
+
+	// Started again from the cache, the docs were published in the meantime
+	writeNeeds(t, upstream, "REQ_1", "REQ_2")
+	index := internal.LoadNeedsIndex(config, logger)
+	if len(index.Needs()) != 1 {
+		t.Fatalf("LoadNeedsIndex() = %v, want the cached needs", index.Needs())
+	}
+	refreshRemoteNeeds(index, config, logger, 0)
+	if len(index.Needs()) != 2 {
+		t.Errorf("Expected the refresh on startup to get REQ_2, got %v", index.Needs())
+	}
+}
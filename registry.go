@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// session is everything a handler works with, one per client connection.
+type session struct {
+	logger *slog.Logger
+	writer *rpc.Writer
+	state  *internal.State
+	client *client
+	routes *registry
+	// Publishes diagnostics off the reading goroutine
+	diagnostics *diagnosticsScheduler
+	// Reloads the needs after the client told us about changed files
+	reloader *needsReloader
+	// Polls the needs.json for clients that can not watch it, nil if polling is off
+	watcher *needsWatcher
+	// Set on initialize, if false the watcher is used
+	clientWatchesFiles bool
+	// Only used on the reading goroutine
+	stopNeedsUpdates func()
+	// The watcher is also started from the goroutine waiting for the client to register, see pollNeedsJson
+	watchMu      sync.Mutex
+	stopWatching func()
+	// Set on close, the watcher may not be started anymore
+	watchingStopped bool
+
+	// lsp.TraceValue, set via initialize and $/setTrace
+	trace atomic.Value
+}
+
+func (s *session) traceValue() lsp.TraceValue {
+	trace, _ := s.trace.Load().(lsp.TraceValue)
+	if trace == "" {
+		return lsp.TraceOff
+	}
+	return trace
+}
+
+func (s *session) setTrace(trace lsp.TraceValue) {
+	switch trace {
+	case lsp.TraceOff, lsp.TraceMessages, lsp.TraceVerbose:
+		s.trace.Store(trace)
+	case "":
+		// Not given, keep what we have
+	default:
+		s.logger.Warn("Ignoring unknown trace value", "value", string(trace))
+	}
+}
+
+// message is an incoming request or notification. id is nil for notifications.
+type message struct {
+	method   string
+	id       *lsp.ID
+	contents []byte
+}
+
+// handlerFunc processes a message. For requests the returned value is the response to write,
+// a returned error is turned into an error response. Notifications return nil, nil.
+type handlerFunc func(ctx context.Context, s *session, msg *message) (any, error)
+
+// middleware wraps the handler of a route, it is applied once when the route is registered.
+type middleware func(rt *route, next handlerFunc) handlerFunc
+
+type route struct {
+	method       string
+	notification bool
+	// inOrder routes may not overtake other messages, they run on the reading goroutine
+	inOrder bool
+	// capability advertises the route in the initialize result, nil if there is nothing to advertise
+	capability func(*lsp.ServerCapabilities)
+	handle     handlerFunc
+}
+
+// registry maps the LSP methods to their handlers.
+type registry struct {
+	routes     map[string]*route
+	middleware []middleware
+	// disabled methods are neither advertised nor handled
+	disabled []string
+}
+
+func newRegistryWithMiddleware(disabled []string, middleware ...middleware) *registry {
+	return &registry{
+		routes:     make(map[string]*route),
+		middleware: middleware,
+		disabled:   disabled,
+	}
+}
+
+func (r *registry) add(rt *route) {
+	// The first middleware is the outermost one
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		rt.handle = r.middleware[i](rt, rt.handle)
+	}
+	r.routes[rt.method] = rt
+}
+
+func (r *registry) lookup(method string) (*route, bool) {
+	rt, ok := r.routes[method]
+	return rt, ok
+}
+
+func (r *registry) isDisabled(method string) bool {
+	return slices.Contains(r.disabled, method)
+}
+
+// capabilities derives what we advertise to the client from the routes that are registered and enabled.
+func (r *registry) capabilities() lsp.ServerCapabilities {
+	var capabilities lsp.ServerCapabilities
+	for method, rt := range r.routes {
+		if rt.capability == nil || r.isDisabled(method) {
+			continue
+		}
+		rt.capability(&capabilities)
+	}
+	return capabilities
+}
+
+// onRequest registers a request handler. The whole message is decoded into T before handle is called.
+func onRequest[T any](r *registry, method string, capability func(*lsp.ServerCapabilities), handle func(ctx context.Context, s *session, request T) (any, error)) *route {
+	rt := &route{
+		method:     method,
+		capability: capability,
+		handle: func(ctx context.Context, s *session, msg *message) (any, error) {
+			var request T
+			if err := json.Unmarshal(msg.contents, &request); err != nil {
+				return nil, lsp.NewResponseError(lsp.InvalidParams, "invalid params: %s", err.Error())
+			}
+			return handle(ctx, s, request)
+		},
+	}
+	r.add(rt)
+	return rt
+}
+
+// onNotification registers a notification handler. The whole message is decoded into T before handle is called.
+func onNotification[T any](r *registry, method string, capability func(*lsp.ServerCapabilities), handle func(ctx context.Context, s *session, notification T) error) *route {
+	rt := &route{
+		method:       method,
+		notification: true,
+		// Notifications change documents, the order they arrive in matters
+		inOrder:    true,
+		capability: capability,
+		handle: func(ctx context.Context, s *session, msg *message) (any, error) {
+			var notification T
+			if err := json.Unmarshal(msg.contents, &notification); err != nil {
+				return nil, fmt.Errorf("could not parse params: %w", err)
+			}
+			return nil, handle(ctx, s, notification)
+		},
+	}
+	r.add(rt)
+	return rt
+}
+
+// handleMessage processes one message. ctx is cancelled if the client sends $/cancelRequest for it.
+func handleMessage(ctx context.Context, s *session, method string, contents []byte) {
+	baseMsg, err := rpc.DecodeBaseMessage(contents)
+	if err != nil {
+		s.logger.Warn("could not decode base message", "err", err)
+		writeResponse(s.writer, lsp.NewErrorResponse(nil, lsp.NewResponseError(lsp.ParseError, "could not parse message: %s", err.Error())))
+		return
+	}
+	msg := &message{method: method, contents: contents}
+	if !baseMsg.IsNotification() {
+		msg.id = requestID(baseMsg)
+		if msg.id == nil {
+			writeResponse(s.writer, lsp.NewErrorResponse(nil, lsp.NewResponseError(lsp.InvalidRequest, "request id must be a number or a string")))
+			return
+		}
+	}
+
+	rt, ok := s.routes.lookup(method)
+	if !ok {
+		// Notifications we do not know (including all '$/' ones) have to be ignored.
+		// Requests always need an answer, otherwise the client waits for it forever.
+		if msg.id == nil {
+			s.logger.Debug("Ignoring unhandled notification", "method", method)
+			return
+		}
+		s.logger.Warn("Method not found", "method", method)
+		writeResponse(s.writer, lsp.NewErrorResponse(msg.id, lsp.NewResponseError(lsp.MethodNotFound, "method not found: %s", method)))
+		return
+	}
+
+	if rt.notification != (msg.id == nil) {
+		if msg.id == nil {
+			s.logger.Warn("Ignoring request that was sent as notification", "method", method)
+			return
+		}
+		writeResponse(s.writer, lsp.NewErrorResponse(msg.id, lsp.NewResponseError(lsp.InvalidRequest, "%s is a notification, it can not be sent as request", method)))
+		return
+	}
+
+	response, err := rt.handle(ctx, s, msg)
+	if msg.id == nil {
+		if err != nil {
+			s.logger.Error("Notification failed", "method", method, "err", err)
+		}
+		return
+	}
+	if err != nil {
+		respErr := toResponseError(err)
+		level := slog.LevelWarn
+		if respErr.Code == lsp.RequestCancelled || respErr.Code == lsp.ContentModified {
+			// Happens all the time while typing, nothing went wrong
+			level = slog.LevelDebug
+		}
+		s.logger.Log(context.Background(), level, "Request failed", "method", method, "id", msg.id.String(), "code", int(respErr.Code), "err", respErr.Message)
+		writeResponse(s.writer, lsp.NewErrorResponse(msg.id, respErr))
+		return
+	}
+	writeResponse(s.writer, response)
+}
+
+// toResponseError maps errors of handlers to the error codes of the spec.
+func toResponseError(err error) *lsp.ResponseError {
+	var respErr *lsp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr
+	}
+	if errors.Is(err, context.Canceled) {
+		return lsp.NewResponseError(lsp.RequestCancelled, "request was cancelled")
+	}
+	return lsp.NewResponseError(lsp.RequestFailed, "%s", err.Error())
+}
+
+// recoverPanics turns a panicking handler into an InternalError instead of taking the server down.
+func recoverPanics(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (response any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logger.Error("Handler panicked", "method", rt.method, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				response = nil
+				err = lsp.NewResponseError(lsp.InternalError, "internal error while handling %s", rt.method)
+			}
+		}()
+		return next(ctx, s, msg)
+	}
+}
+
+// logTiming logs how long each message took.
+func logTiming(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (any, error) {
+		start := time.Now()
+		response, err := next(ctx, s, msg)
+		s.logger.Debug("Handled message", "method", rt.method, "duration", time.Since(start))
+		return response, err
+	}
+}
+
+// checkLifecycle rejects messages the server can not handle in its current lifecycle status.
+func checkLifecycle(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (any, error) {
+		if msg.id == nil {
+			if !s.state.AcceptsNotification(rt.method) {
+				s.logger.Debug("Dropping notification", "method", rt.method, "status", s.state.Status().String())
+				return nil, nil
+			}
+		} else if respErr := s.state.CheckRequest(rt.method); respErr != nil {
+			return nil, respErr
+		}
+		return next(ctx, s, msg)
+	}
+}
+
+// gateCapabilities answers requests for disabled methods as if they did not exist.
+// They are not advertised either, so a well behaved client never sends them.
+func gateCapabilities(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (any, error) {
+		if s.routes.isDisabled(rt.method) {
+			if msg.id == nil {
+				return nil, nil
+			}
+			return nil, lsp.NewResponseError(lsp.MethodNotFound, "method not found: %s", rt.method)
+		}
+		return next(ctx, s, msg)
+	}
+}
+
+// checkContentModified answers requests with ContentModified if their document changed while they ran,
+// the result could point to ranges that do not exist anymore.
+func checkContentModified(rt *route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, s *session, msg *message) (any, error) {
+		if msg.id == nil {
+			return next(ctx, s, msg)
+		}
+		var request struct {
+			Params struct {
+				TextDocument *lsp.TextDocumentIdentifier `json:"textDocument"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(msg.contents, &request); err != nil || request.Params.TextDocument == nil {
+			return next(ctx, s, msg)
+		}
+		uri := request.Params.TextDocument.URI
+		before, versioned := s.state.DocumentVersion(uri)
+		response, err := next(ctx, s, msg)
+		if err != nil {
+			return response, err
+		}
+		if after, ok := s.state.DocumentVersion(uri); after != before || ok != versioned {
+			return nil, lsp.NewResponseError(lsp.ContentModified, "%s changed from version %d to %d while handling %s", uri, before, after, rt.method)
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+func TestRegistryCapabilities(t *testing.T) {
+	capabilities := newRegistry(nil).capabilities()
+	if !capabilities.HoverProvider || !capabilities.DefinitionProvider || !capabilities.ReferencesProvider {
+		t.Errorf("Expected all language features to be advertised, got %+v", capabilities)
+	}
+	if capabilities.CompletionProvider == nil {
+		t.Error("Expected completion to be advertised")
+	}
+	sync := capabilities.TextDocumentSync
+	if sync == nil || !sync.OpenClose || sync.Change != lsp.TextDocumentSyncIncremental || sync.Save == nil || !sync.Save.IncludeText {
+		t.Errorf("Expected open/close, incremental changes and save with its text to be advertised, got %+v", sync)
+	}
+
+	capabilities = newRegistry([]string{"textDocument/hover", "textDocument/completion"}).capabilities()
+	if capabilities.HoverProvider {
+		t.Error("Expected disabled hover not to be advertised")
+	}
+	if capabilities.CompletionProvider != nil {
+		t.Error("Expected disabled completion not to be advertised")
+	}
+	if !capabilities.DefinitionProvider {
+		t.Error("Expected definition to still be advertised")
+	}
+
+	capabilities = newRegistry([]string{"textDocument/didSave"}).capabilities()
+	if capabilities.TextDocumentSync == nil || capabilities.TextDocumentSync.Save != nil {
+		t.Errorf("Expected didSave not to be advertised, got %+v", capabilities.TextDocumentSync)
+	}
+}
+
+func TestRegistryMiddleware(t *testing.T) {
+	r := newRegistryWithMiddleware([]string{"test/disabled"}, recoverPanics, checkLifecycle, gateCapabilities, checkContentModified)
+	onRequest(r, "initialize", nil, handleInitialize)
+	onRequest(r, "test/panic", nil, func(context.Context, *session, lsp.Request) (any, error) {
+		var m map[string]int
+		m["boom"] = 1
+		return nil, nil
+	})
+	onRequest(r, "test/params", nil, func(context.Context, *session, lsp.HoverRequest) (any, error) {
+		t.Error("Handler must not be called with undecodable params")
+		return nil, nil
+	})
+	onRequest(r, "test/disabled", nil, func(context.Context, *session, lsp.Request) (any, error) {
+		t.Error("Disabled handler must not be called")
+		return nil, nil
+	})
+
+	onRequest(r, "test/modifies", nil, func(_ context.Context, s *session, request lsp.HoverRequest) (any, error) {
+		// Like a didChange that is handled while the request runs
+		err := s.state.ChangeDocument(request.Params.TextDocument.URI, 2, []lsp.TextDocumentContentChangeEvent{{Text: "changed"}})
+		return lsp.HoverResponse{Response: lsp.Response{RPC: "2.0", ID: &request.ID}}, err
+	})
+
+	onRequest(r, "test/cancelled", nil, func(context.Context, *session, lsp.Request) (any, error) {
+		return nil, context.Canceled
+	})
+
+	var out, logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	s := &session{
+		logger: logger,
+		writer: rpc.NewWriter(&out),
+		state:  internal.NewState(internal.ServerConfig{}, logger),
+		routes: r,
+	}
+
+	handleMessage(context.Background(), s, "initialize", []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	s.state.OpenDocumentVersion("file:///a.py", 1, "")
+	out.Reset()
+
+	tests := []struct {
+		name     string
+		method   string
+		content  string
+		wantCode string
+		// Cancelled and outdated requests are normal while typing
+		wantWarning bool
+	}{
+		{
+			name:        "panic becomes internal error",
+			method:      "test/panic",
+			content:     `{"jsonrpc":"2.0","id":2,"method":"test/panic"}`,
+			wantCode:    `"code":-32603`,
+			wantWarning: true,
+		},
+		{
+			name:        "disabled method is not found",
+			method:      "test/disabled",
+			content:     `{"jsonrpc":"2.0","id":3,"method":"test/disabled"}`,
+			wantCode:    `"code":-32601`,
+			wantWarning: true,
+		},
+		{
+			name:        "undecodable params",
+			method:      "test/params",
+			content:     `{"jsonrpc":"2.0","id":4,"method":"test/params","params":[]}`,
+			wantCode:    `"code":-32602`,
+			wantWarning: true,
+		},
+		{
+			name:     "document changed while handling",
+			method:   "test/modifies",
+			content:  `{"jsonrpc":"2.0","id":5,"method":"test/modifies","params":{"textDocument":{"uri":"file:///a.py"},"position":{"line":0,"character":0}}}`,
+			wantCode: `"code":-32801`,
+		},
+		{
+			name:     "cancelled",
+			method:   "test/cancelled",
+			content:  `{"jsonrpc":"2.0","id":6,"method":"test/cancelled"}`,
+			wantCode: `"code":-32800`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			logs.Reset()
+			handleMessage(context.Background(), s, tt.method, []byte(tt.content))
+			if !strings.Contains(out.String(), tt.wantCode) {
+				t.Errorf("Expected %s, got %s", tt.wantCode, out.String())
+			}
+			if warned := strings.Contains(logs.String(), "Request failed"); warned != tt.wantWarning {
+				t.Errorf("Expected a warning %v, got %q", tt.wantWarning, logs.String())
+			}
+		})
+	}
+}
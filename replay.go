@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// runReplay implements 'scl_ls replay [flags] <recording>'.
+// It returns 0 if the replay matched the recording, 1 if it did not and 2 on errors.
+func runReplay(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	needsPath := flags.String("needsPath", "needs.json", "The path to the needs.json the recording was made with")
+	needsVersion := flags.String("needsVersion", "", "Version of the needs.json the recording was made with")
+	otherNeedsVersions := flags.String("otherNeedsVersions", "", "Other versions (comma seperated) the recording was made with")
+	var needsSources needsSourcesFlag
+	flags.Var(&needsSources, "needsSource", "Other needs.json files the recording was made with, can be repeated")
+	docsPath := flags.String("docsPath", "docs", "The path to your docs folder")
+	rstNeeds := flags.Bool("rstNeeds", false, "If the recording was made with the needs of the rst files")
+	templateStrings := flags.String("templateStrings", "# req-Id:,# req-traceability:", "Template strings (comma seperated) the recording was made with")
+	disabledMethods := flags.String("disable", "", "LSP methods (comma seperated) that were disabled")
+	hoverFields := flags.String("hoverFields", "", "Hover fields (comma seperated) the recording was made with")
+	idCharacters := flags.String("idCharacters", internal.DefaultIDCharacters, "ID characters the recording was made with")
+	logLevel := flags.String("logLevel", "error", "Minimum level that is logged: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(flags.Output(), "usage: scl_ls replay [flags] <recording>")
+		return 2
+	}
+	logger, err := newLogger("", *logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sclls: %s\n", err.Error())
+		return 2
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sclls: %s\n", err.Error())
+		return 2
+	}
+	defer file.Close()
+	recording, err := rpc.ReadRecording(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sclls: could not read recording: %s\n", err.Error())
+		return 2
+	}
+
+	config := internal.ServerConfig{
+		Enabled:            true,
+		NeedsJsonPath:      *needsPath,
+		NeedsVersion:       *needsVersion,
+		OtherNeedsVersions: splitList(*otherNeedsVersions),
+		NeedsSources:       needsSources,
+		DocumentRootPath:   *docsPath,
+		RstNeeds:           *rstNeeds,
+		TemplateStrings:    strings.Split(*templateStrings, ","),
+		DisabledMethods:    splitList(*disabledMethods),
+		HoverFields:        splitList(*hoverFields),
+		IDCharacters:       *idCharacters,
+	}
+	srv := &server{
+		routes: newRegistry(config.DisabledMethods),
+		config: config,
+		needs:  internal.LoadNeedsIndex(config, logger),
+		logger: logger,
+	}
+	differences, err := srv.replay(recording)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sclls: %s\n", err.Error())
+		return 2
+	}
+	for _, difference := range differences {
+		fmt.Fprintln(stdout, difference)
+	}
+	fmt.Fprintf(stdout, "replayed %d messages, %d differences\n", len(recording), len(differences))
+	if len(differences) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replay feeds the client messages of a recording one after the other through a fresh session
+// and returns how the answers differ from the recorded ones.
+// Responses are matched by their id, notifications are compared in order per method.
+// Diagnostics are debounced, so only the last ones of every document are compared.
+func (srv *server) replay(recording []rpc.RecordedMessage) ([]string, error) {
+	var out bytes.Buffer
+	d := newDispatcher(srv.routes, srv.logger, rpc.NewWriter(&out), internal.NewSession(srv.config, srv.needs, srv.logger))
+	s := d.session
+	// Nobody answers our requests during a replay, they have to fail instead of waiting forever
+	s.client.caller.Close()
+
+	recorded := newTranscript()
+	for _, msg := range recording {
+		content := msg.Content()
+		if msg.Direction == rpc.DirectionOut {
+			recorded.add(content)
+			continue
+		}
+		if s.state.Status() == internal.StatusExited {
+			continue
+		}
+		baseMsg, err := rpc.DecodeBaseMessage(content)
+		if err == nil && (baseMsg.IsResponse() || baseMsg.Method == "$/cancelRequest") {
+			// Every request is finished before the next message, there is nothing left to answer or cancel
+			continue
+		}
+		handleMessage(context.Background(), s, baseMsg.Method, content)
+		s.diagnostics.flush()
+	}
+	d.close()
+
+	replayed := newTranscript()
+	reader := rpc.NewReader(&out)
+	reader.MaxMessageSize = 0
+	for {
+		content, err := reader.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read replayed messages: %w", err)
+		}
+		replayed.add(content)
+	}
+	return recorded.diff(replayed), nil
+}
+
+// transcript collects the messages the server sent, grouped so they can be compared.
+type transcript struct {
+	keys     []string
+	messages map[string][]string
+}
+
+func newTranscript() *transcript {
+	return &transcript{messages: make(map[string][]string)}
+}
+
+func (t *transcript) add(content []byte) {
+	baseMsg, err := rpc.DecodeBaseMessage(content)
+	if err != nil {
+		t.append("unparsable message", string(content))
+		return
+	}
+	var key string
+	switch {
+	case baseMsg.IsResponse():
+		key = "response to " + string(bytes.TrimSpace(baseMsg.ID))
+	case !baseMsg.IsNotification():
+		// Our own requests to the client are not replayed, see replay
+		return
+	case baseMsg.Method == "$/logTrace" || baseMsg.Method == "window/logMessage":
+		// Contain timings and log output, they never match
+		return
+	case baseMsg.Method == "textDocument/publishDiagnostics":
+		var notification lsp.PublishDiagnosticsNotificiation
+		if err := json.Unmarshal(content, &notification); err != nil {
+			t.append("unparsable message", string(content))
+			return
+		}
+		key = "diagnostics of " + notification.Params.URI
+		if _, ok := t.messages[key]; ok {
+			// How many were published in between depends on the timing
+			t.messages[key] = t.messages[key][:0]
+		}
+	default:
+		key = baseMsg.Method
+	}
+	t.append(key, normalize(content))
+}
+
+func (t *transcript) append(key string, message string) {
+	if _, ok := t.messages[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.messages[key] = append(t.messages[key], message)
+}
+
+// diff lists every message that is missing, unexpected or different in other.
+func (t *transcript) diff(other *transcript) []string {
+	var differences []string
+	keys := slices.Clone(t.keys)
+	for _, key := range other.keys {
+		if _, ok := t.messages[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		recorded, replayed := t.messages[key], other.messages[key]
+		for i := range max(len(recorded), len(replayed)) {
+			switch {
+			case i >= len(replayed):
+				differences = append(differences, fmt.Sprintf("%s #%d: missing\n  recorded: %s", key, i+1, recorded[i]))
+			case i >= len(recorded):
+				differences = append(differences, fmt.Sprintf("%s #%d: unexpected\n  replayed: %s", key, i+1, replayed[i]))
+			case recorded[i] != replayed[i]:
+				differences = append(differences, fmt.Sprintf("%s #%d: differs\n  recorded: %s\n  replayed: %s", key, i+1, recorded[i], replayed[i]))
+			}
+		}
+	}
+	return differences
+}
+
+// normalize re-encodes a message, so the order of the fields and whitespace do not matter.
+func normalize(content []byte) string {
+	var value any
+	if err := json.Unmarshal(content, &value); err != nil {
+		return string(content)
+	}
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return string(content)
+	}
+	return string(normalized)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sclls/rpc"
+)
+
+func frames(contents ...string) string {
+	var sb strings.Builder
+	for _, content := range contents {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	}
+	return sb.String()
+}
+
+// recordSession runs a small editor session and returns its recording.
+func recordSession(t *testing.T) []rpc.RecordedMessage {
+	t.Helper()
+	var recording bytes.Buffer
+	srv := newTestServer()
+	srv.recorder = rpc.NewRecorder(&recording)
+	input := frames(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.py","languageId":"python","version":1,"text":"# req-Id: REQ_001\n# req-Id: REQ_404\n"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition","params":{"textDocument":{"uri":"file:///a.py"},"position":{"line":0,"character":12}}}`,
+		`{"jsonrpc":"2.0","id":"3","method":"textDocument/unknown","params":{}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if code := srv.serve(strings.NewReader(input), io.Discard); code != 0 {
+		t.Fatalf("serve() = %d, want 0", code)
+	}
+	messages, err := rpc.ReadRecording(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestReplay(t *testing.T) {
+	recording := recordSession(t)
+	differences, err := newTestServer().replay(recording)
+	if err != nil {
+		t.Fatalf("replay() unexpected error = %v", err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("Expected the replay to match the recording, got %v", differences)
+	}
+
+	// Pretend the server used to answer differently
+	for i, msg := range recording {
+		if msg.Direction == rpc.DirectionOut && strings.Contains(string(msg.Message), `"id":2`) {
+			recording[i].Message = []byte(`{"jsonrpc":"2.0","id":2,"result":null}`)
+		}
+	}
+	recording = append(recording, rpc.RecordedMessage{Direction: rpc.DirectionOut, Message: []byte(`{"jsonrpc":"2.0","id":5,"result":null}`)})
+	differences, err = newTestServer().replay(recording)
+	if err != nil {
+		t.Fatalf("replay() unexpected error = %v", err)
+	}
+	if len(differences) != 2 {
+		t.Fatalf("Expected 2 differences, got %v", differences)
+	}
+	if !strings.HasPrefix(differences[0], "response to 2 #1: differs") || !strings.HasPrefix(differences[1], "response to 5 #1: missing") {
+		t.Errorf("Unexpected differences %v", differences)
+	}
+
+	// A regression that publishes diagnostics nobody expected
+	var withoutDiagnostics []rpc.RecordedMessage
+	for _, msg := range recordSession(t) {
+		if msg.Direction != rpc.DirectionOut || !strings.Contains(string(msg.Message), "textDocument/publishDiagnostics") {
+			withoutDiagnostics = append(withoutDiagnostics, msg)
+		}
+	}
+	differences, err = newTestServer().replay(withoutDiagnostics)
+	if err != nil {
+		t.Fatalf("replay() unexpected error = %v", err)
+	}
+	if len(differences) != 1 || !strings.HasPrefix(differences[0], "diagnostics of file:///a.py #1: unexpected") {
+		t.Errorf("Expected the replayed diagnostics to be unexpected, got %v", differences)
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := rpc.NewRecorder(&recording)
+	recorder.Record(rpc.DirectionIn, []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`))
+	recorder.Record(rpc.DirectionOut, []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"server not initialized, can not handle textDocument/hover"}}`))
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, recording.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if code := runReplay([]string{"-needsPath", filepath.Join(t.TempDir(), "missing.json"), path}, &out); code != 0 {
+		t.Errorf("runReplay() = %d, want 0, output: %s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "replayed 2 messages, 0 differences") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	if code := runReplay([]string{filepath.Join(t.TempDir(), "missing.jsonl")}, &out); code != 2 {
+		t.Errorf("runReplay() = %d for a missing recording, want 2", code)
+	}
+}
@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ErrClosed is returned by calls that were still waiting when the connection went away.
+var ErrClosed = errors.New("connection closed before the response arrived")
+
+// RemoteError is the error the other side answered a call with.
+type RemoteError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Message)
+}
+
+type requestMessage struct {
+	RPC    string `json:"jsonrpc"`
+	ID     int64  `json:"id"`
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+type notificationMessage struct {
+	RPC    string `json:"jsonrpc"`
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+type responseMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RemoteError    `json:"error"`
+}
+
+// Caller sends requests to the other side of the connection and matches the responses to them.
+// Responses have to be handed in via Deliver by whoever reads the connection,
+// so Call must never be used from the reading goroutine itself.
+type Caller struct {
+	writer *Writer
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan responseMessage
+	closed  bool
+}
+
+func NewCaller(writer *Writer) *Caller {
+	return &Caller{writer: writer, pending: make(map[string]chan responseMessage)}
+}
+
+// Call sends a request and waits for its response. The result is unmarshalled into result,
+// which may be nil if it is not of interest. A response with an error returns a *RemoteError.
+func (c *Caller) Call(ctx context.Context, method string, params any, result any) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	key := strconv.FormatInt(id, 10)
+	// Buffered, so Deliver never blocks on a caller that already gave up
+	responseChan := make(chan responseMessage, 1)
+	c.pending[key] = responseChan
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	if err := c.writer.Write(requestMessage{RPC: "2.0", ID: id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case response, ok := <-responseChan:
+		if !ok {
+			return ErrClosed
+		}
+		if response.Error != nil {
+			return response.Error
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(response.Result, result)
+	}
+}
+
+// Notify sends a notification, there is nothing to wait for.
+func (c *Caller) Notify(method string, params any) error {
+	return c.writer.Write(notificationMessage{RPC: "2.0", Method: method, Params: params})
+}
+
+// Deliver hands a response to the Call waiting for it.
+// It reports false if the content is no response or nobody waits for it (anymore).
+func (c *Caller) Deliver(content []byte) bool {
+	var response responseMessage
+	if err := json.Unmarshal(content, &response); err != nil {
+		return false
+	}
+	key := string(bytes.TrimSpace(response.ID))
+	c.mu.Lock()
+	responseChan, ok := c.pending[key]
+	if ok {
+		delete(c.pending, key)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	responseChan <- response
+	return true
+}
+
+// Close fails all calls that still wait and makes new ones fail right away.
+func (c *Caller) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for key, responseChan := range c.pending {
+		close(responseChan)
+		delete(c.pending, key)
+	}
+}
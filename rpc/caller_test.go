@@ -0,0 +1,101 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sclls/rpc"
+	"testing"
+	"time"
+)
+
+type testRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+}
+
+// startClient reads the requests of the caller and answers each one via answer.
+func startClient(t *testing.T, caller *rpc.Caller, reader *rpc.Reader, answer func(testRequest) string) {
+	t.Helper()
+	go func() {
+		for {
+			content, err := reader.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request testRequest
+			if err := json.Unmarshal(content, &request); err != nil {
+				return
+			}
+			reply := answer(request)
+			if reply == "" {
+				continue
+			}
+			caller.Deliver([]byte(reply))
+		}
+	}()
+}
+
+func TestCallerCall(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	caller := rpc.NewCaller(rpc.NewWriter(pw))
+	startClient(t, caller, rpc.NewReader(pr), func(request testRequest) string {
+		switch request.Method {
+		case "workspace/configuration":
+			return `{"jsonrpc":"2.0","id":` + string(request.ID) + `,"result":[{"needsJsonPath":"needs.json"}]}`
+		case "client/registerCapability":
+			return `{"jsonrpc":"2.0","id":` + string(request.ID) + `,"error":{"code":-32601,"message":"nope"}}`
+		}
+		return ""
+	})
+
+	var result []map[string]string
+	if err := caller.Call(context.Background(), "workspace/configuration", map[string]any{"items": []any{}}, &result); err != nil {
+		t.Fatalf("Call() unexpected error = %v", err)
+	}
+	if len(result) != 1 || result[0]["needsJsonPath"] != "needs.json" {
+		t.Errorf("Call() result = %v", result)
+	}
+
+	err := caller.Call(context.Background(), "client/registerCapability", nil, nil)
+	var remoteErr *rpc.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != -32601 {
+		t.Errorf("Call() error = %v, want a *rpc.RemoteError with code -32601", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := caller.Call(ctx, "window/showMessageRequest", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() without answer error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCallerClose(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	caller := rpc.NewCaller(rpc.NewWriter(pw))
+	// Never answers
+	startClient(t, caller, rpc.NewReader(pr), func(testRequest) string { return "" })
+
+	done := make(chan error)
+	go func() {
+		done <- caller.Call(context.Background(), "window/workDoneProgress/create", nil, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	caller.Close()
+	if err := <-done; !errors.Is(err, rpc.ErrClosed) {
+		t.Errorf("Call() error = %v, want %v", err, rpc.ErrClosed)
+	}
+	if err := caller.Call(context.Background(), "workspace/applyEdit", nil, nil); !errors.Is(err, rpc.ErrClosed) {
+		t.Errorf("Call() after Close() error = %v, want %v", err, rpc.ErrClosed)
+	}
+}
+
+func TestCallerDeliverUnknown(t *testing.T) {
+	caller := rpc.NewCaller(rpc.NewWriter(io.Discard))
+	if caller.Deliver([]byte(`{"jsonrpc":"2.0","id":42,"result":null}`)) {
+		t.Error("Expected Deliver() to report false for a response nobody waits for")
+	}
+}
@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxMessageSize is the biggest message content the Reader accepts if nothing else is configured.
+const DefaultMaxMessageSize = 64 << 20
+
+// FrameError reports a single malformed message. The Reader already skipped it,
+// so reading can continue with the next message.
+type FrameError struct {
+	Reason string
+}
+
+func (e *FrameError) Error() string {
+	return "malformed message: " + e.Reason
+}
+
+// Reader reads LSP framed messages ('<headers>\r\n\r\n<content>') from a stream.
+type Reader struct {
+	r *bufio.Reader
+	// MaxMessageSize limits the content length of a single message. Bigger messages are skipped.
+	MaxMessageSize int
+	// Recorder gets every message that was read, if set
+	Recorder *Recorder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), MaxMessageSize: DefaultMaxMessageSize}
+}
+
+// ReadMessage returns the content of the next message.
+// A *FrameError means only this message was broken, any other error ends the stream.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	header, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	contentLength, err := parseHeader(header)
+	if err != nil {
+		if contentLength > 0 {
+			// We know where the content ends, so skip it and stay in sync
+			if _, discardErr := io.CopyN(io.Discard, r.r, int64(contentLength)); discardErr != nil {
+				return nil, discardErr
+			}
+		}
+		return nil, err
+	}
+	if r.MaxMessageSize > 0 && contentLength > r.MaxMessageSize {
+		if _, err := io.CopyN(io.Discard, r.r, int64(contentLength)); err != nil {
+			return nil, err
+		}
+		return nil, &FrameError{Reason: fmt.Sprintf("content length %d exceeds the maximum of %d", contentLength, r.MaxMessageSize)}
+	}
+	content := make([]byte, contentLength)
+	if _, err := io.ReadFull(r.r, content); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.Recorder != nil {
+		r.Recorder.Record(DirectionIn, content)
+	}
+	return content, nil
+}
+
+// readHeader collects the lines up to the empty line that ends the header block.
+// Leading empty lines and garbage in front of a 'Content-Length' (left overs of
+// a previous broken frame) are skipped.
+func (r *Reader) readHeader() ([]byte, error) {
+	var header bytes.Buffer
+	for {
+		line, err := r.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Way too long for a header line. Drop it, the frame is broken anyway.
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.r.ReadSlice('\n')
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, r.skipHeader(&FrameError{Reason: "header line too long"})
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && header.Len() > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if len(trimmed) == 0 {
+			if header.Len() == 0 {
+				continue
+			}
+			return header.Bytes(), nil
+		}
+		if header.Len() == 0 {
+			trimmed = resync(trimmed)
+		}
+		header.Write(trimmed)
+		header.WriteByte('\n')
+	}
+}
+
+// skipHeader discards the rest of a broken header block and returns frameErr.
+func (r *Reader) skipHeader(frameErr *FrameError) error {
+	for {
+		line, err := r.r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+		if err == nil && len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return frameErr
+		}
+	}
+}
+
+// resync cuts away anything in front of a 'Content-Length' that is not at the start of the line.
+func resync(line []byte) []byte {
+	idx := bytes.Index(bytes.ToLower(line), []byte("content-length:"))
+	if idx <= 0 {
+		return line
+	}
+	return line[idx:]
+}
+
+// parseHeader checks all header fields and returns the content length.
+// Field names are case insensitive, their order does not matter.
+// If the header is broken the content length is still returned when it is known (-1 otherwise),
+// so the caller can skip the content.
+func parseHeader(header []byte) (int, error) {
+	contentLength := -1
+	var frameErr *FrameError
+	for _, line := range strings.Split(strings.TrimRight(string(header), "\n"), "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			frameErr = &FrameError{Reason: fmt.Sprintf("invalid header line %q", line)}
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			length, err := strconv.Atoi(value)
+			if err != nil || length < 0 {
+				return -1, &FrameError{Reason: fmt.Sprintf("invalid Content-Length %q", value)}
+			}
+			contentLength = length
+		case "content-type":
+			// We only speak utf-8, 'utf8' is accepted for backwards compatibility as the spec asks.
+			if _, charset, ok := strings.Cut(strings.ToLower(value), "charset="); ok {
+				charset = strings.Trim(strings.TrimSpace(charset), `"`)
+				if charset != "utf-8" && charset != "utf8" {
+					frameErr = &FrameError{Reason: fmt.Sprintf("unsupported charset %q", charset)}
+				}
+			}
+		}
+	}
+	if contentLength == -1 {
+		return -1, &FrameError{Reason: "missing Content-Length header"}
+	}
+	if frameErr != nil {
+		return contentLength, frameErr
+	}
+	return contentLength, nil
+}
@@ -0,0 +1,159 @@
+package rpc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sclls/rpc"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "single message",
+			input: "Content-Length: 13\r\n\r\nHello, World!",
+			want:  []string{"Hello, World!"},
+		},
+		{
+			name:  "multiple messages back to back",
+			input: "Content-Length: 5\r\n\r\nHelloContent-Length: 5\r\n\r\nWorld",
+			want:  []string{"Hello", "World"},
+		},
+		{
+			name:  "content type and different order",
+			input: "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\nContent-Length: 2\r\n\r\n{}",
+			want:  []string{"{}"},
+		},
+		{
+			name:  "case insensitive header names",
+			input: "content-length: 2\r\n\r\n[]CONTENT-LENGTH:3\r\n\r\nabc",
+			want:  []string{"[]", "abc"},
+		},
+		{
+			name:  "content containing header like text",
+			input: "Content-Length: 23\r\n\r\nContent-Length: 1\r\n\r\nab",
+			want:  []string{"Content-Length: 1\r\n\r\nab"},
+		},
+		{
+			name:  "bare newlines",
+			input: "Content-Length: 2\n\n{}",
+			want:  []string{"{}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := rpc.NewReader(strings.NewReader(tt.input))
+			var got []string
+			for {
+				content, err := reader.ReadMessage()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadMessage() unexpected error = %v", err)
+				}
+				got = append(got, string(content))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ReadMessage() returned %d messages %q, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ReadMessage()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessageRecovers(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		max   int
+	}{
+		{
+			name:  "invalid content length",
+			input: "Content-Length: abc\r\n\r\nContent-Length: 2\r\n\r\n{}",
+		},
+		{
+			name:  "missing content length",
+			input: "Content-Type: foo\r\n\r\nContent-Length: 2\r\n\r\n{}",
+		},
+		{
+			name:  "unsupported charset",
+			input: "Content-Length: 3\r\nContent-Type: text/plain; charset=latin1\r\n\r\nabcContent-Length: 2\r\n\r\n{}",
+		},
+		{
+			name:  "message bigger than the maximum",
+			input: "Content-Length: 10\r\n\r\n0123456789Content-Length: 2\r\n\r\n{}",
+			max:   5,
+		},
+		{
+			name:  "garbage in front of header",
+			input: "garbage\r\n\r\nContent-Length: 2\r\n\r\n{}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := rpc.NewReader(strings.NewReader(tt.input))
+			if tt.max > 0 {
+				reader.MaxMessageSize = tt.max
+			}
+			_, err := reader.ReadMessage()
+			var frameErr *rpc.FrameError
+			if !errors.As(err, &frameErr) {
+				t.Fatalf("ReadMessage() error = %v, want a *rpc.FrameError", err)
+			}
+			content, err := reader.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() after broken frame unexpected error = %v", err)
+			}
+			if string(content) != "{}" {
+				t.Errorf("ReadMessage() = %q, want %q", content, "{}")
+			}
+		})
+	}
+}
+
+func TestReadMessageNoTokenLimit(t *testing.T) {
+	big := strings.Repeat("x", 1<<20)
+	input := "Content-Length: 1048576\r\n\r\n" + big
+	content, err := rpc.NewReader(strings.NewReader(input)).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() unexpected error = %v", err)
+	}
+	if len(content) != len(big) {
+		t.Errorf("ReadMessage() returned %d bytes, want %d", len(content), len(big))
+	}
+}
+
+func TestReadMessageTruncated(t *testing.T) {
+	_, err := rpc.NewReader(strings.NewReader("Content-Length: 10\r\n\r\nabc")).ReadMessage()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadMessage() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := rpc.NewWriter(&out)
+	if err := writer.Write(EncodingExmpl{Testing: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Content-Length: 16\r\n\r\n{\"Testing\":true}"
+	if out.String() != expected {
+		t.Fatalf("Expected: %q, Actual: %q", expected, out.String())
+	}
+	if err := writer.Write(make(chan int)); err == nil {
+		t.Error("Expected an error for a value that can not be marshalled")
+	}
+}
@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Direction tells who sent a recorded message, 'in' is client to server.
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+)
+
+// RecordedMessage is one line of a recording.
+type RecordedMessage struct {
+	Time      time.Time       `json:"time"`
+	Direction Direction       `json:"direction"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// Content returns the message as it was sent.
+// Content that was no valid JSON is recorded as JSON string, it is unquoted again here.
+func (m RecordedMessage) Content() []byte {
+	var invalid string
+	if json.Unmarshal(m.Message, &invalid) == nil {
+		return []byte(invalid)
+	}
+	return m.Message
+}
+
+// Recorder writes every message it gets as one JSON line, so a session can be replayed later.
+// It is safe for concurrent use.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, now: time.Now}
+}
+
+func (r *Recorder) Record(direction Direction, content []byte) error {
+	message := json.RawMessage(content)
+	if !json.Valid(content) {
+		// Broken messages are the interesting ones, keep them instead of failing
+		quoted, err := json.Marshal(string(content))
+		if err != nil {
+			return err
+		}
+		message = quoted
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	line, err := json.Marshal(RecordedMessage{Time: r.now(), Direction: direction, Message: message})
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadRecording parses a recording written by a Recorder.
+func ReadRecording(r io.Reader) ([]RecordedMessage, error) {
+	var recording []RecordedMessage
+	scanner := bufio.NewScanner(r)
+	// Lines are as long as the biggest message, e.g. a didOpen of a huge file
+	scanner.Buffer(nil, DefaultMaxMessageSize*2)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var msg RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNr, err)
+		}
+		if msg.Direction != DirectionIn && msg.Direction != DirectionOut {
+			return nil, fmt.Errorf("line %d: unknown direction %q", lineNr, msg.Direction)
+		}
+		recording = append(recording, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
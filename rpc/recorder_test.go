@@ -0,0 +1,71 @@
+package rpc_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"sclls/rpc"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := rpc.NewRecorder(&recording)
+
+	reader := rpc.NewReader(strings.NewReader("Content-Length: 15\r\n\r\n{\"method\":\"hi\"}Content-Length: 5\r\n\r\nnope}"))
+	reader.Recorder = recorder
+	writer := rpc.NewWriter(io.Discard)
+	writer.Recorder = recorder
+
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(EncodingExmpl{Testing: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := rpc.ReadRecording(&recording)
+	if err != nil {
+		t.Fatalf("ReadRecording() unexpected error = %v", err)
+	}
+	expected := []struct {
+		direction rpc.Direction
+		content   string
+	}{
+		{rpc.DirectionIn, `{"method":"hi"}`},
+		{rpc.DirectionOut, `{"Testing":true}`},
+		{rpc.DirectionIn, `nope}`},
+	}
+	if len(messages) != len(expected) {
+		t.Fatalf("ReadRecording() returned %d messages, want %d", len(messages), len(expected))
+	}
+	for i, want := range expected {
+		if messages[i].Direction != want.direction || string(messages[i].Content()) != want.content {
+			t.Errorf("message %d = %s %q, want %s %q", i, messages[i].Direction, messages[i].Content(), want.direction, want.content)
+		}
+		if messages[i].Time.IsZero() {
+			t.Errorf("message %d has no timestamp", i)
+		}
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording string
+	}{
+		{"not json", "hello\n"},
+		{"unknown direction", `{"time":"2024-01-01T00:00:00Z","direction":"sideways","message":{}}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := rpc.ReadRecording(strings.NewReader(tt.recording)); err == nil {
+				t.Error("ReadRecording() expected an error")
+			}
+		})
+	}
+}
@@ -5,20 +5,38 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
 )
 
-func EncodeMsg(msg any) string {
+func EncodeMsg(msg any) (string, error) {
 	content, err := json.Marshal(msg)
 	if err != nil {
-		// TODO: Do not panic here
-		panic(err)
+		return "", err
 	}
-	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content), nil
 }
 
 type BaseMessage struct {
-	Method string `json:"method"`
+	Method string          `json:"method"`
+	ID     json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the message carries no id and therefore expects no reply.
+func (m BaseMessage) IsNotification() bool {
+	return len(m.ID) == 0 || string(m.ID) == "null"
+}
+
+// IsResponse reports whether the message answers a request we sent.
+func (m BaseMessage) IsResponse() bool {
+	return m.Method == "" && !m.IsNotification()
+}
+
+// DecodeBaseMessage reads the fields every JSON-RPC message shares from its content.
+func DecodeBaseMessage(content []byte) (BaseMessage, error) {
+	var baseMsg BaseMessage
+	if err := json.Unmarshal(content, &baseMsg); err != nil {
+		return BaseMessage{}, err
+	}
+	return baseMsg, nil
 }
 
 func DecodeMsg(msg []byte) (string, []byte, error) {
@@ -26,12 +44,13 @@ func DecodeMsg(msg []byte) (string, []byte, error) {
 	if !found {
 		return "", nil, errors.New("did not find header")
 	}
-	// Content-Length: <number>
-	contentLenghtBytes := header[len("Content-Length: "):]
-	contentLength, err := strconv.Atoi(string(contentLenghtBytes))
+	contentLength, err := parseHeader(bytes.ReplaceAll(header, []byte{'\r', '\n'}, []byte{'\n'}))
 	if err != nil {
 		return "", nil, err
 	}
+	if len(content) < contentLength {
+		return "", nil, errors.New("content is shorter than Content-Length")
+	}
 
 	var baseMsg BaseMessage
 	if err := json.Unmarshal(content[:contentLength], &baseMsg); err != nil {
@@ -39,24 +58,3 @@ func DecodeMsg(msg []byte) (string, []byte, error) {
 	}
 	return baseMsg.Method, content[:contentLength], nil
 }
-
-// type SplitFunc func(data []byte, atEOF bool) (advance int, token []byte, err error)
-func Split(data []byte, _ bool) (advance int, token []byte, err error) {
-	header, content, found := bytes.Cut(data, []byte{'\r', '\n', '\r', '\n'})
-	if !found {
-		return 0, nil, nil
-	}
-	// Content-Length: <number>
-	contentLenghtBytes := header[len("Content-Length: "):]
-	contentLength, err := strconv.Atoi(string(contentLenghtBytes))
-	if err != nil {
-		// 'LS got no idea what to do here => error'
-		return 0, nil, err
-	}
-	if len(content) < contentLength {
-		return 0, nil, nil
-	}
-	totalLenght := len(header) + 4 + contentLength
-
-	return totalLenght, data[:totalLenght], nil
-}
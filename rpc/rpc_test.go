@@ -1,7 +1,6 @@
 package rpc_test
 
 import (
-	"reflect"
 	"sclls/rpc"
 	"testing"
 )
@@ -12,12 +11,21 @@ type EncodingExmpl struct {
 
 func TestEncodeMsg(t *testing.T) {
 	expected := "Content-Length: 16\r\n\r\n{\"Testing\":true}"
-	actual := rpc.EncodeMsg(EncodingExmpl{Testing: true})
+	actual, err := rpc.EncodeMsg(EncodingExmpl{Testing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if expected != actual {
 		t.Fatalf("Expected: %s, Actual: %s", expected, actual)
 	}
 }
 
+func TestEncodeMsgError(t *testing.T) {
+	if _, err := rpc.EncodeMsg(make(chan int)); err == nil {
+		t.Fatal("Expected an error for a value that can not be marshalled")
+	}
+}
+
 func TestDecodeMsg(t *testing.T) {
 	incMsg := "Content-Length: 15\r\n\r\n{\"Method\":\"hi\"}"
 	// TODO Add content testing
@@ -34,70 +42,53 @@ func TestDecodeMsg(t *testing.T) {
 	}
 }
 
-func TestSplit(t *testing.T) {
+func TestDecodeBaseMessage(t *testing.T) {
 	tests := []struct {
-		name        string
-		input       []byte
-		wantAdvance int
-		wantToken   []byte
-		wantErr     bool
+		name             string
+		content          string
+		wantMethod       string
+		wantNotification bool
+		wantErr          bool
 	}{
 		{
-			name:        "complete valid message",
-			input:       []byte("Content-Length: 13\r\n\r\nHello, World!"),
-			wantAdvance: 35,
-			wantToken:   []byte("Content-Length: 13\r\n\r\nHello, World!"),
-			wantErr:     false,
+			name:             "request with id",
+			content:          `{"jsonrpc":"2.0","id":3,"method":"textDocument/hover"}`,
+			wantMethod:       "textDocument/hover",
+			wantNotification: false,
 		},
 		{
-			name:        "exact content length match",
-			input:       []byte("Content-Length: 5\r\n\r\nHello"),
-			wantAdvance: 26,
-			wantToken:   []byte("Content-Length: 5\r\n\r\nHello"),
-			wantErr:     false,
+			name:             "notification without id",
+			content:          `{"jsonrpc":"2.0","method":"initialized"}`,
+			wantMethod:       "initialized",
+			wantNotification: true,
 		},
 		{
-			name:        "incomplete message - no delimiter",
-			input:       []byte("Content-Length: 13"),
-			wantAdvance: 0,
-			wantToken:   nil,
-			wantErr:     false,
+			name:             "null id",
+			content:          `{"jsonrpc":"2.0","id":null,"method":"$/progress"}`,
+			wantMethod:       "$/progress",
+			wantNotification: true,
 		},
 		{
-			name:        "invalid content length",
-			input:       []byte("Content-Length: abc\r\n\r\nSome content"),
-			wantAdvance: 0,
-			wantToken:   nil,
-			wantErr:     true,
+			name:    "invalid json",
+			content: `{"jsonrpc":`,
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAdvance, gotToken, err := rpc.Split(tt.input, false)
-
-			// Check error expectation
-			if tt.wantErr {
-				if err == nil {
-					t.Errorf("Split() expected error but got none")
-				}
-				return
+			baseMsg, err := rpc.DecodeBaseMessage([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBaseMessage() error = %v, wantErr %v", err, tt.wantErr)
 			}
-
-			if err != nil {
-				t.Errorf("Split() unexpected error = %v", err)
+			if tt.wantErr {
 				return
 			}
-
-			// Check advance
-			if gotAdvance != tt.wantAdvance {
-				t.Errorf("Split() advance = %v, want %v", gotAdvance, tt.wantAdvance)
+			if baseMsg.Method != tt.wantMethod {
+				t.Errorf("DecodeBaseMessage() method = %q, want %q", baseMsg.Method, tt.wantMethod)
 			}
-
-			// Check token
-			if !reflect.DeepEqual(gotToken, tt.wantToken) {
-				t.Errorf("Split() token = %v, want %v", gotToken, tt.wantToken)
-				t.Errorf("Split() token string = %q, want %q", string(gotToken), string(tt.wantToken))
+			if baseMsg.IsNotification() != tt.wantNotification {
+				t.Errorf("IsNotification() = %v, want %v", baseMsg.IsNotification(), tt.wantNotification)
 			}
 		})
 	}
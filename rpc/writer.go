@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Writer writes LSP framed messages. It is safe for concurrent use,
+// every message is written in one piece so replies can not interleave.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	// Recorder gets every message that was written, if set
+	Recorder *Recorder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write marshals msg and sends it as one framed message.
+func (w *Writer) Write(msg any) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.WriteMessage(content)
+}
+
+// WriteMessage frames the already encoded content and sends it.
+func (w *Writer) WriteMessage(content []byte) error {
+	frame := fmt.Appendf(nil, "Content-Length: %d\r\n\r\n", len(content))
+	frame = append(frame, content...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Recorder != nil {
+		// Under the lock, so the recording has the same order as the stream
+		w.Recorder.Record(DirectionOut, content)
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
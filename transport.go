@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"sclls/internal"
+	"sclls/lsp"
+	"sclls/rpc"
+)
+
+// server holds everything the sessions of all connections share.
+type server struct {
+	routes *registry
+	config internal.ServerConfig
+	needs  *internal.NeedsIndex
+	logger *slog.Logger
+	// logToClient forwards warnings and errors to the editor
+	logToClient    bool
+	maxMessageSize int
+	// recorder gets every message in both directions, nil if nothing is recorded
+	recorder *rpc.Recorder
+	// Polls the needs.json for sessions whose client can not watch it, nil if polling is off
+	watcher *needsWatcher
+}
+
+// serve runs one session until the client sends 'exit' or closes the connection.
+// It returns the exit code the session ended with.
+func (srv *server) serve(r io.Reader, w io.Writer) int {
+	writer := rpc.NewWriter(w)
+	writer.Recorder = srv.recorder
+	logger := srv.logger
+	if srv.logToClient {
+		logger = slog.New(newClientLogHandler(logger.Handler(), writer, slog.LevelWarn))
+	}
+	state := internal.NewSession(srv.config, srv.needs, logger)
+	reader := rpc.NewReader(r)
+	reader.MaxMessageSize = srv.maxMessageSize
+	reader.Recorder = srv.recorder
+	d := newDispatcher(srv.routes, logger, writer, state)
+	d.watcher = srv.watcher
+	defer d.close()
+	for {
+		content, err := reader.ReadMessage()
+		if err != nil {
+			var frameErr *rpc.FrameError
+			if !errors.As(err, &frameErr) {
+				// The client closed the connection without sending 'exit'
+				if !errors.Is(err, io.EOF) {
+					logger.Error("stopped reading messages", "err", err)
+				}
+				break
+			}
+			logger.Warn("skipped malformed message", "err", err)
+			// We can not know the id of a message we could not parse, the spec wants 'null' then.
+			writeResponse(d.writer, lsp.NewErrorResponse(nil, lsp.NewResponseError(lsp.ParseError, "could not parse message: %s", err.Error())))
+			continue
+		}
+		baseMsg, err := rpc.DecodeBaseMessage(content)
+		if err != nil {
+			logger.Warn("could not parse message", "err", err)
+			writeResponse(d.writer, lsp.NewErrorResponse(nil, lsp.NewResponseError(lsp.ParseError, "could not parse message: %s", err.Error())))
+			continue
+		}
+		d.dispatch(baseMsg.Method, content)
+		if state.Status() == internal.StatusExited {
+			logger.Info("Received exit, stopping session", "exitCode", state.ExitCode())
+			return state.ExitCode()
+		}
+	}
+	return state.Exit()
+}
+
+// listen accepts connections on address until the listener fails.
+// Every connection gets its own session.
+func (srv *server) listen(network, address string) error {
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	srv.logger.Info("Listening", "network", network, "address", ln.Addr().String())
+	return srv.acceptLoop(ln)
+}
+
+func (srv *server) acceptLoop(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			srv.logger.Info("New connection", "remote", conn.RemoteAddr().String())
+			code := srv.serve(conn, conn)
+			srv.logger.Info("Connection closed", "remote", conn.RemoteAddr().String(), "exitCode", code)
+		}()
+	}
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous run.
+// Anything that is not a socket is left alone, so Listen fails loudly instead.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Type() != fs.ModeSocket {
+		return
+	}
+	os.Remove(path)
+}
+
+// parseListenAddress splits '<network>:<address>' as given to --listen,
+// e.g. 'tcp:127.0.0.1:9257' or 'unix:/tmp/sclls.sock'.
+func parseListenAddress(listen string) (string, string, error) {
+	network, address, found := strings.Cut(listen, ":")
+	if !found || address == "" {
+		return "", "", fmt.Errorf("listen address %q has to look like <network>:<address>", listen)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("unsupported network %q, use tcp or unix", network)
+}
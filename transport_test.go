@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sclls/internal"
+	"sclls/rpc"
+)
+
+func newTestServer() *server {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	needs := internal.NewNeedsIndex(internal.NeedsInfo{
+		"REQ_001": internal.Need{ID: "REQ_001", Docname: "requirements", Lineno: 10},
+	})
+	return &server{
+		routes:         newRegistry(nil),
+		config:         internal.ServerConfig{TemplateStrings: []string{"# req-Id: "}},
+		needs:          needs,
+		logger:         logger,
+		maxMessageSize: rpc.DefaultMaxMessageSize,
+	}
+}
+
+// call sends a framed message and returns the content of the next message from the server.
+func call(t *testing.T, conn net.Conn, reader *rpc.Reader, content string) string {
+	t.Helper()
+	if _, err := fmt.Fprintf(conn, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		t.Fatalf("could not send message: %v", err)
+	}
+	reply, err := reader.ReadMessage()
+	if err != nil {
+		t.Fatalf("could not read reply: %v", err)
+	}
+	return string(reply)
+}
+
+func TestServeConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{name: "tcp", network: "tcp", address: "127.0.0.1:0"},
+		{name: "unix socket", network: "unix", address: filepath.Join(t.TempDir(), "sclls.sock")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer()
+			ln, err := net.Listen(tt.network, tt.address)
+			if err != nil {
+				t.Skipf("can not listen on %s: %v", tt.network, err)
+			}
+			defer ln.Close()
+			go srv.acceptLoop(ln)
+
+			// Two clients at once, each with its own session
+			for i := range 2 {
+				conn, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				reader := rpc.NewReader(conn)
+
+				reply := call(t, conn, reader, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+				if !strings.Contains(reply, `"capabilities"`) {
+					t.Fatalf("client %d: expected initialize result, got %s", i, reply)
+				}
+				reply = call(t, conn, reader, `{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///none.py"},"position":{"line":0,"character":0}}}`)
+				if !strings.Contains(reply, `"id":2`) {
+					t.Fatalf("client %d: expected completion result, got %s", i, reply)
+				}
+				reply = call(t, conn, reader, `{"jsonrpc":"2.0","id":3,"method":"shutdown"}`)
+				if !strings.Contains(reply, `"result":null`) {
+					t.Fatalf("client %d: expected shutdown result, got %s", i, reply)
+				}
+				fmt.Fprintf(conn, "Content-Length: 33\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}")
+				if _, err := reader.ReadMessage(); err != io.EOF {
+					t.Errorf("client %d: expected the server to close the connection, got %v", i, err)
+				}
+			}
+			if srv.needs.Needs()["REQ_001"].ID != "REQ_001" {
+				t.Error("Expected shutdown of a session to leave the shared needs index alone")
+			}
+		})
+	}
+}
+
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		listen      string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{listen: "tcp:127.0.0.1:9257", wantNetwork: "tcp", wantAddress: "127.0.0.1:9257"},
+		{listen: "unix:/tmp/sclls.sock", wantNetwork: "unix", wantAddress: "/tmp/sclls.sock"},
+		{listen: "127.0.0.1:9257", wantErr: true},
+		{listen: "tcp:", wantErr: true},
+		{listen: "udp:127.0.0.1:9257", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			network, address, err := parseListenAddress(tt.listen)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListenAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if network != tt.wantNetwork || address != tt.wantAddress {
+				t.Errorf("parseListenAddress() = %s, %s, want %s, %s", network, address, tt.wantNetwork, tt.wantAddress)
+			}
+		})
+	}
+}